@@ -2,6 +2,7 @@ package main
 
 import (
 	"embed"
+	htmltemplate "html/template"
 	"path"
 	"text/template"
 	"traefik-lazyload/pkg/config"
@@ -12,7 +13,13 @@ import (
 //go:embed assets/*
 var httpAssets embed.FS
 
-const httpAssetPrefix = "/__llassets/"
+const (
+	httpAssetPrefix     = "/__llassets/"
+	httpProviderPath    = "/__llprovider"
+	httpForwardAuthPath = "/__llauth"
+	httpErrorPagePath   = "/__llerror"
+	httpHeartbeatPath   = "/__llheartbeat"
+)
 
 type SplashModel struct {
 	*service.ContainerState
@@ -23,17 +30,56 @@ type StatusPageModel struct {
 	Active         []*service.ContainerState
 	Qualifying     []containers.Wrapper
 	Providers      []containers.Wrapper
+	LabelProblems  map[string][]service.LabelProblem // Container ID -> problems with its labels
+	DryRun         bool
+	DryRunActions  []service.Event
+	Events         []service.Event
+	EventFilter    service.EventFilter
+	EventTypes     []service.EventType
+	Usage          []service.UsageReport
+	UsageBy        string // Label usage is grouped by (empty is per container)
+	ConfigError    string // Why the last config reload was rejected
 	RuntimeMetrics string
 }
 
+// Event types offered in the status page's filter
+var eventTypes = []service.EventType{
+	service.EventStartRequested,
+	service.EventDependencyStarted,
+	service.EventReady,
+	service.EventIdleStopped,
+	service.EventEvicted,
+	service.EventExternallyStopped,
+	service.EventStartFailed,
+	service.EventHookFailed,
+	service.EventWouldStart,
+	service.EventWouldStop,
+}
+
 type assetTemplates struct {
 	splash *template.Template
-	status *template.Template
+	status *htmltemplate.Template // Shows untrusted input, eg. user agents
 }
 
 func LoadTemplates() *assetTemplates {
-	return &assetTemplates{
-		splash: template.Must(template.ParseFS(httpAssets, path.Join("assets", config.Model.Splash))),
-		status: template.Must(template.ParseFS(httpAssets, "assets/status.html")),
+	ret, err := loadTemplates(config.Current().Splash)
+	if err != nil {
+		panic(err)
 	}
+	return ret
+}
+
+func loadTemplates(splash string) (*assetTemplates, error) {
+	splashTmpl, err := template.ParseFS(httpAssets, path.Join("assets", splash))
+	if err != nil {
+		return nil, err
+	}
+	statusTmpl, err := htmltemplate.ParseFS(httpAssets, "assets/status.html")
+	if err != nil {
+		return nil, err
+	}
+	return &assetTemplates{
+		splash: splashTmpl,
+		status: statusTmpl,
+	}, nil
 }
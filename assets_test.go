@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusPageHidesSecrets(t *testing.T) {
+	prev := config.Current()
+	t.Cleanup(func() { config.Apply(prev) })
+	config.Update(func(cfg *config.ConfigModel) {
+		cfg.LabelPrefix = "lazyloader"
+		cfg.Splash = "splash.html"
+	})
+
+	assets, err := loadTemplates(config.Current().Splash)
+	assert.NoError(t, err)
+
+	ct := containers.Wrapper{Summary: container.Summary{ID: "a", Names: []string{"/app"}, Labels: map[string]string{
+		"lazyloader":                "true",
+		"lazyloader.heartbeattoken": "s3cret-token",
+		"lazyloader.stopdelay":      "5m",
+	}}}
+	var out strings.Builder
+	assert.NoError(t, assets.status.Execute(&out, StatusPageModel{
+		Qualifying: []containers.Wrapper{ct},
+		Providers:  []containers.Wrapper{ct},
+	}))
+
+	assert.NotContains(t, out.String(), "s3cret-token")
+	assert.Contains(t, out.String(), "heartbeattoken")
+	assert.Contains(t, out.String(), "stopdelay")
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/service"
+	"traefik-lazyload/pkg/traefik"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+type command struct {
+	name  string
+	args  string // Positional arguments, for the usage
+	about string
+	run   func(flags *pflag.FlagSet) error
+	flags func(flags *pflag.FlagSet) // Command-specific flags
+}
+
+var commands = []command{
+	{name: "serve", about: "Run the lazyloader (the default)", run: serve},
+	{name: "status", about: "List managed containers and their state", run: statusCommand},
+	{name: "start", args: "<host>", about: "Start the container serving a host", run: startCommand, flags: func(flags *pflag.FlagSet) {
+		flags.Bool("wait", false, "Wait until the container is ready")
+	}},
+	{name: "stop", args: "<host|container>", about: "Stop a container, and dependencies no longer needed", run: stopCommand},
+	{name: "stop-all", about: "Stop all managed containers", run: stopAllCommand},
+	{name: "explain", args: "<host|url>", about: "Show how a host is routed, and the settings of the container it's routed to", run: explainCommand},
+	{name: "lint", about: "Check the config and container labels, exiting non-zero on problems", run: lintCommand, flags: func(flags *pflag.FlagSet) {
+		flags.Bool("labels", true, "Check the labels of the containers in docker")
+	}},
+}
+
+func main() {
+	args := os.Args[1:]
+	name, named := "serve", false
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args, named = args[0], args[1:], true
+	}
+
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		printUsage()
+		os.Exit(2)
+	}
+
+	flags := pflag.NewFlagSet(cmd.name, pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [flags] %s\n\n%s\n\nFlags:\n", os.Args[0], cmd.name, cmd.args, cmd.about)
+		flags.PrintDefaults()
+	}
+	config.AddFlags(flags)
+	if cmd.flags != nil {
+		cmd.flags(flags)
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			if !named {
+				printUsage()
+			}
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+
+	if err := config.Load(flags); err != nil {
+		logrus.Fatal(err)
+	}
+	switch {
+	case config.Current().Verbose:
+		logrus.SetLevel(logrus.DebugLevel)
+		logrus.Debug("Verbose is on")
+	case cmd.name != "serve":
+		// Keep the output to the command's own
+		logrus.SetLevel(logrus.WarnLevel)
+	}
+
+	if err := cmd.run(flags); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.about)
+	}
+	w.Flush()
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> --help' for its flags\n", os.Args[0])
+}
+
+// Positional argument i, or an error naming what's missing
+func requireArg(flags *pflag.FlagSet, i int, what string) (string, error) {
+	if flags.NArg() <= i {
+		return "", fmt.Errorf("missing %s", what)
+	}
+	return flags.Arg(i), nil
+}
+
+// Creates a core for a one-off command. It must not write the files of a running server
+func newCLICore(ctx context.Context) (*service.Core, error) {
+	config.Update(func(cfg *config.ConfigModel) {
+		cfg.EventLog, cfg.UsageFile = "", ""
+	})
+
+	dockerClient := mustCreateDockerClient()
+	return service.NewWithoutPolling(dockerClient, newDiscovery(ctx, dockerClient))
+}
+
+func statusCommand(_ *pflag.FlagSet) error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Current().Timeout)
+	defer cancel()
+
+	dockerClient := mustCreateDockerClient()
+	defer dockerClient.Close()
+	discovery := newDiscovery(ctx, dockerClient)
+
+	qualifying, err := discovery.QualifyingContainers(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER\tSTATE\tHOSTS\tNEEDS")
+	for _, ct := range qualifying {
+		hosts, patterns, err := discovery.ContainerHosts(ctx, ct.ID)
+		if err != nil {
+			return err
+		}
+		needs, _ := ct.ConfigCSV("needs", nil)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", ct.NameID(), ct.State, orNone(append(hosts, patterns...)), orNone(needs))
+	}
+	return w.Flush()
+}
+
+func startCommand(flags *pflag.FlagSet) error {
+	host, err := requireArg(flags, 0, "host")
+	if err != nil {
+		return err
+	}
+	wait, _ := flags.GetBool("wait")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	core, err := newCLICore(ctx)
+	if err != nil {
+		return err
+	}
+	defer core.Close()
+
+	ets, err := core.StartHost(host, service.Requester{UserAgent: "cli"})
+	if err != nil {
+		return err
+	}
+
+	// Without --wait, only until docker was asked to start it (and its dependencies)
+	done := func(state service.State) bool {
+		switch state {
+		case service.StateStartingDeps, service.StateStarting:
+			return false
+		case service.StateWaitingReady:
+			return !wait
+		}
+		return true
+	}
+	deadline := time.Now().Add(config.Current().Timeout)
+	for !done(ets.State()) && time.Now().Before(deadline) {
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	state := ets.State()
+	switch {
+	case state == service.StateFailed:
+		return fmt.Errorf("%s failed to start", ets.Name())
+	case wait && !state.Ready() && !config.Current().DryRun:
+		return fmt.Errorf("%s not ready after %s (%s)", ets.Name(), config.Current().Timeout, state)
+	}
+	fmt.Printf("%s: %s\n", ets.Name(), state)
+
+	// Don't wait for a start that's been left to finish on its own
+	shutdownCtx, cancelShutdown := context.WithCancel(ctx)
+	cancelShutdown()
+	core.Shutdown(shutdownCtx)
+	return nil
+}
+
+func stopCommand(flags *pflag.FlagSet) error {
+	target, err := requireArg(flags, 0, "host or container")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	core, err := newCLICore(ctx)
+	if err != nil {
+		return err
+	}
+	defer core.Close()
+
+	ets, err := core.Stop(ctx, target)
+	if err != nil {
+		return fmt.Errorf("%s: %w", target, err)
+	}
+	fmt.Printf("%s: %s\n", ets.Name(), ets.State())
+	return nil
+}
+
+func stopAllCommand(_ *pflag.FlagSet) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	core, err := newCLICore(ctx)
+	if err != nil {
+		return err
+	}
+	defer core.Close()
+
+	core.StopAll(ctx)
+	return nil
+}
+
+func explainCommand(flags *pflag.FlagSet) error {
+	host, err := requireArg(flags, 0, "host or URL")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Current().Timeout)
+	defer cancel()
+
+	dockerClient := mustCreateDockerClient()
+	defer dockerClient.Close()
+	discovery := newDiscovery(ctx, dockerClient)
+
+	explanation, err := service.Explain(ctx, discovery, host)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Hostname:\t%s\n", explanation.Hostname)
+	for _, skipped := range explanation.Skipped {
+		fmt.Fprintf(w, "Skipped route source:\t%s\n", skipped)
+	}
+	for _, candidate := range explanation.Candidates {
+		fmt.Fprintf(w, "\n%s\n", candidate.Container)
+		for _, rule := range candidate.Rules {
+			mark := "-"
+			if rule.Matched {
+				mark = "+"
+			}
+			fmt.Fprintf(w, "  %s %s\t%s %s\t%s\n", mark, rule.Source, rule.Kind, rule.Value, rule.Reason)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	if explanation.Winner == nil {
+		return fmt.Errorf("%s: %s", explanation.Hostname, explanation.Why)
+	}
+	ct := explanation.Winner
+	fmt.Printf("Winner: %s (%s)\n", explanation.WinnerName, explanation.Why)
+	fmt.Printf("State: %s\n\n", ct.State)
+
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE\tFROM")
+	for _, setting := range explanation.Settings {
+		value := setting.Value
+		if value == "" {
+			value = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", setting.Name, value, setting.Source)
+	}
+
+	needs, _ := ct.ConfigCSV("needs", nil)
+	for _, need := range needs {
+		providers, err := discovery.FindDepProvider(ctx, need)
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(providers))
+		for _, p := range providers {
+			names = append(names, fmt.Sprintf("%s [%s]", p.NameID(), p.State))
+		}
+		fmt.Fprintf(w, "needs %s\t%s\tprovider\n", need, orNone(names))
+	}
+	return w.Flush()
+}
+
+// The config itself is validated by config.Load; this checks what it refers to
+func lintCommand(flags *pflag.FlagSet) error {
+	var problems []string
+	if _, err := loadTemplates(config.Current().Splash); err != nil {
+		problems = append(problems, fmt.Sprintf("splash: %v", err))
+	}
+	if _, err := traefik.NewAccessLogFilter(config.Current().AccessLogIgnoreAgents, config.Current().AccessLogIgnoreIPs); err != nil {
+		problems = append(problems, fmt.Sprintf("access log filter: %v", err))
+	}
+	paths := []struct{ name, path string }{
+		{"traefikconfig", config.Current().TraefikConfig},
+		{"accesslog", config.Current().AccessLog},
+		{"procroot", config.Current().ProcRoot},
+	}
+	if config.Current().ProcRoot != "" {
+		paths = append(paths, struct{ name, path string }{"cgrouproot", config.Current().CgroupRoot})
+	}
+	for _, p := range paths {
+		if p.path == "" {
+			continue
+		}
+		if _, err := os.Stat(p.path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", p.name, err))
+		}
+	}
+	if labels, _ := flags.GetBool("labels"); labels {
+		labelProblems, err := lintLabels()
+		if err != nil {
+			return err
+		}
+		problems = append(problems, labelProblems...)
+	}
+
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		return fmt.Errorf("%d problem(s) found", len(problems))
+	}
+	fmt.Println("OK")
+	return nil
+}
+
+// Problems with the labels of all qualifying containers, prefixed with the container
+func lintLabels() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Current().Timeout)
+	defer cancel()
+
+	dockerClient := mustCreateDockerClient()
+	defer dockerClient.Close()
+	discovery := newDiscovery(ctx, dockerClient)
+
+	byID, err := service.LintLabels(ctx, discovery)
+	if err != nil {
+		return nil, err
+	}
+	qualifying, err := discovery.QualifyingContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]string, 0)
+	for _, ct := range qualifying {
+		for _, problem := range byID[ct.ID] {
+			ret = append(ret, fmt.Sprintf("%s: %s", ct.Name(), problem))
+		}
+	}
+	return ret, nil
+}
+
+func orNone(items []string) string {
+	if len(items) == 0 {
+		return "-"
+	}
+	return strings.Join(items, ", ")
+}
@@ -2,26 +2,39 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"traefik-lazyload/pkg/config"
 	"traefik-lazyload/pkg/containers"
 	"traefik-lazyload/pkg/service"
+	"traefik-lazyload/pkg/traefik"
 
 	"github.com/docker/docker/client"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
 )
 
 type controller struct {
-	assets    assetTemplates
+	assets    atomic.Pointer[assetTemplates] // Replaced on config reload
 	core      *service.Core
 	discovery *containers.Discovery
+
+	reloadMux sync.Mutex
+	configErr error // Why the last reload was rejected
 }
 
 func mustCreateDockerClient() *client.Client {
@@ -33,61 +46,137 @@ func mustCreateDockerClient() *client.Client {
 	return cli
 }
 
-func main() {
-	config.Load()
+// Creates discovery, with the configured traefik route sources. File providers are watched until ctx is done
+func newDiscovery(ctx context.Context, dockerClient *client.Client) *containers.Discovery {
+	discovery := containers.NewDiscovery(dockerClient)
 
-	if config.Model.Verbose {
-		logrus.SetLevel(logrus.DebugLevel)
-		logrus.Debug("Verbose is on")
+	if config.Current().TraefikAPI != "" {
+		logrus.Infof("Resolving routes via traefik API at %s", config.Current().TraefikAPI)
+		discovery.AddRouteSource(traefik.NewAPIClient(config.Current().TraefikAPI, &http.Client{Timeout: config.Current().Timeout}))
+	}
+	if config.Current().TraefikConfig != "" {
+		fileProvider, err := traefik.NewFileProvider(config.Current().TraefikConfig)
+		if err != nil {
+			logrus.Fatal("Unable to load traefik config: ", err)
+		}
+		logrus.Infof("Resolving routes via traefik config at %s", config.Current().TraefikConfig)
+		discovery.AddRouteSource(fileProvider)
+		go func() {
+			if err := fileProvider.Watch(ctx, func() { discovery.RefreshIndex(ctx) }); err != nil {
+				logrus.Warnf("Unable to watch traefik config for changes: %v", err)
+			}
+		}()
 	}
 
+	return discovery
+}
+
+// Runs the lazyloader: serves http, and starts and stops containers until SIGTERM/SIGINT
+func serve(_ *pflag.FlagSet) error {
 	dockerClient := mustCreateDockerClient()
-	discovery := containers.NewDiscovery(dockerClient)
 
-	var err error
-	core, err := service.New(dockerClient, discovery, config.Model.PollFreq)
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	discovery := newDiscovery(watchCtx, dockerClient)
+	go discovery.WatchEvents(watchCtx)
+
+	core, err := service.New(dockerClient, discovery, config.Current().PollFreq)
 	if err != nil {
-		logrus.Fatal(err)
+		return err
 	}
 	defer core.Close()
 
-	if config.Model.StopAtBoot {
-		core.StopAll()
+	if config.Current().TraefikMetrics != "" {
+		logrus.Infof("Reading request activity from traefik metrics at %s", config.Current().TraefikMetrics)
+		core.AddActivitySource(traefik.NewMetricsClient(config.Current().TraefikMetrics, &http.Client{Timeout: config.Current().Timeout}))
+	}
+	if config.Current().AccessLog != "" {
+		filter, err := traefik.NewAccessLogFilter(config.Current().AccessLogIgnoreAgents, config.Current().AccessLogIgnoreIPs)
+		if err != nil {
+			return fmt.Errorf("invalid access log filter: %w", err)
+		}
+		logrus.Infof("Reading request activity from %s", config.Current().AccessLog)
+		go traefik.NewAccessLogTailer(config.Current().AccessLog).Tail(watchCtx, func(entry *traefik.AccessLogEntry) {
+			if !filter.Ignore(entry) {
+				core.RecordRequest(entry.RequestHost, entry.RouterName, entry.ServiceName)
+			}
+		})
+	}
+
+	if config.Current().StopAtBoot {
+		core.StopAll(context.Background())
 	}
 
 	controller := controller{
-		*LoadTemplates(),
-		core,
-		discovery,
+		core:      core,
+		discovery: discovery,
 	}
+	controller.assets.Store(LoadTemplates())
+	go controller.WatchConfig(watchCtx)
 
 	// Set up http server
 	subFs, _ := fs.Sub(httpAssets, "assets")
 	router := http.NewServeMux()
 	router.Handle(httpAssetPrefix, http.StripPrefix(httpAssetPrefix, http.FileServer(http.FS(subFs))))
+	if config.Current().ProviderService != "" {
+		logrus.Infof("Serving traefik http-provider config at %s", httpProviderPath)
+		router.HandleFunc(httpProviderPath, controller.ProviderHandler)
+	}
+	if config.Current().ForwardAuth {
+		logrus.Infof("Serving traefik forward-auth at %s", httpForwardAuthPath)
+		router.HandleFunc(httpForwardAuthPath, controller.ForwardAuthHandler)
+	}
+	if config.Current().ErrorPages {
+		logrus.Infof("Serving traefik errors-middleware pages at %s", httpErrorPagePath)
+		router.HandleFunc(httpErrorPagePath, controller.ErrorPageHandler)
+	}
+	if config.Current().Heartbeat {
+		logrus.Infof("Serving heartbeats at %s", httpHeartbeatPath)
+		router.HandleFunc(httpHeartbeatPath, controller.HeartbeatHandler)
+	}
 	router.HandleFunc("/", controller.ContainerHandler)
 
 	srv := &http.Server{
-		Addr:    config.Model.Listen,
+		Addr:    config.Current().Listen,
 		Handler: router,
 	}
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt)
+	sigCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
 
+	logrus.Infof("Listening on %s...", config.Current().Listen)
+	if config.Current().StatusHost != "" {
+		logrus.Infof("Status host set to %s", config.Current().StatusHost)
+	}
 	go func() {
-		<-sigChan
-		logrus.Info("Shutting down...")
-		srv.Shutdown(context.Background())
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logrus.Fatal(err)
+		}
 	}()
 
-	logrus.Infof("Listening on %s...", config.Model.Listen)
-	if config.Model.StatusHost != "" {
-		logrus.Infof("Status host set to %s", config.Model.StatusHost)
+	<-sigCtx.Done()
+	stopSignals() // A second signal kills immediately
+	logrus.Info("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Current().ShutdownTimeout)
+	defer cancel()
+
+	// Drain requests first, so nothing new is started while waiting on in-flight starts
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logrus.Warnf("Error draining http connections: %v", err)
 	}
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		logrus.Fatal(err)
+	if err := core.Shutdown(shutdownCtx); err != nil {
+		logrus.Warnf("Error waiting for in-flight starts: %v", err)
 	}
+	stopWatching()
+
+	if config.Current().StopAtExit {
+		stopCtx, cancel := context.WithTimeout(context.Background(), config.Current().ShutdownTimeout)
+		defer cancel()
+		core.StopAll(stopCtx)
+	}
+	logrus.Info("Shutdown complete")
+	return nil
 }
 
 func (s *controller) ContainerHandler(w http.ResponseWriter, r *http.Request) {
@@ -98,22 +187,32 @@ func (s *controller) ContainerHandler(w http.ResponseWriter, r *http.Request) {
 		io.WriteString(w, "Not Found")
 		return
 	}
-	if host == config.Model.StatusHost && config.Model.StatusHost != "" {
+	if host == config.Current().StatusHost && config.Current().StatusHost != "" {
 		s.StatusHandler(w, r)
 		return
 	}
 
-	if sOpts, err := s.core.StartHost(host); err != nil {
-		if errors.Is(err, containers.ErrNotFound) {
+	s.startHostWithSplash(w, r, host, http.StatusAccepted)
+}
+
+// Starts the container for host, and responds with the splash page using the given status code
+func (s *controller) startHostWithSplash(w http.ResponseWriter, r *http.Request, host string, statusCode int) {
+	if sOpts, err := s.core.StartHost(host, requesterOf(r)); err != nil {
+		switch {
+		case errors.Is(err, containers.ErrNotFound):
 			w.WriteHeader(http.StatusNotFound)
 			io.WriteString(w, "not found")
-		} else {
+		case errors.Is(err, service.ErrShuttingDown):
+			w.Header().Set("Retry-After", "5")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, err.Error())
+		default:
 			w.WriteHeader(http.StatusInternalServerError)
 			io.WriteString(w, err.Error())
 		}
 	} else {
-		w.WriteHeader(http.StatusAccepted)
-		renderErr := s.assets.splash.Execute(w, SplashModel{
+		w.WriteHeader(statusCode)
+		renderErr := s.assets.Load().splash.Execute(w, SplashModel{
 			Hostname:       host,
 			ContainerState: sOpts,
 		})
@@ -123,6 +222,109 @@ func (s *controller) ContainerHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Who made the request, for the event history
+func requesterOf(r *http.Request) service.Requester {
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+	return service.Requester{
+		ClientIP:     clientIP,
+		UserAgent:    r.UserAgent(),
+		ForwardedFor: r.Header.Get("X-Forwarded-For"),
+	}
+}
+
+// Handles traefik forwardAuth requests. Answers 200 (letting the request through, and counting it
+// as activity) when the container is ready, otherwise starts it and answers with the splash page,
+// which traefik passes to the client
+func (s *controller) ForwardAuthHandler(w http.ResponseWriter, r *http.Request) {
+	host := r.Header.Get("X-Forwarded-Host")
+	logrus.Debugf("Handle forward-auth for host: %s %s %s", r.Header.Get("X-Forwarded-Method"), host, r.Header.Get("X-Forwarded-Uri"))
+	if host == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "missing X-Forwarded-Host")
+		return
+	}
+
+	if _, ready := s.core.HostReady(host); ready {
+		s.core.RecordRequest(host, "", "")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Whatever the method. Must not be 2xx, or traefik will forward the request to the (not yet
+	// ready) app
+	w.Header().Set("Retry-After", "1")
+	s.startHostWithSplash(w, r, host, http.StatusServiceUnavailable)
+}
+
+// Handles requests from traefik's errors middleware (eg. a 502/503 from a stopped backend whose
+// router still exists), recovering the original host, starting it and serving the splash page.
+// Errors of a running container are the app's own, so they're passed through instead
+func (s *controller) ErrorPageHandler(w http.ResponseWriter, r *http.Request) {
+	host := errorPageHost(r)
+	logrus.Debugf("Handle error page for host: %s (status %s)", host, r.URL.Query().Get("status"))
+	if host == "" {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "Not Found")
+		return
+	}
+
+	if _, ready := s.core.HostReady(host); ready {
+		status := http.StatusServiceUnavailable
+		if code, err := strconv.Atoi(r.URL.Query().Get("status")); err == nil && code >= 400 && code <= 599 {
+			status = code
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, http.StatusText(status))
+		return
+	}
+
+	w.Header().Set("Retry-After", "1")
+	s.startHostWithSplash(w, r, host, http.StatusServiceUnavailable)
+}
+
+// Recover the original hostname (without a port) of a request forwarded by the errors middleware.
+// Prefers the {url} query placeholder, then the forwarded headers, then the host header (traefik
+// keeps the original)
+func errorPageHost(r *http.Request) string {
+	if rawURL := r.URL.Query().Get("url"); rawURL != "" {
+		if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+			return u.Hostname()
+		}
+	}
+	host := r.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		host = r.Host
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		return hostname
+	}
+	return host
+}
+
+// Lets containers report their own activity, identified by their heartbeat token
+func (s *controller) HeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.FormValue("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	if ct, ok := s.core.Heartbeat(token); ok {
+		logrus.Debugf("Heartbeat from %s", ct.Name())
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, "unknown token")
+	}
+}
+
 func (s *controller) StatusHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.URL.Path {
 	case "/":
@@ -131,15 +333,136 @@ func (s *controller) StatusHandler(w http.ResponseWriter, r *http.Request) {
 
 		qualifying, _ := s.discovery.QualifyingContainers(r.Context())
 		providers, _ := s.discovery.ProviderContainers(r.Context())
+		labelProblems, err := service.LintLabels(r.Context(), s.discovery)
+		if err != nil {
+			logrus.Warnf("Unable to check container labels: %v", err)
+		}
 
-		s.assets.status.Execute(w, StatusPageModel{
+		eventFilter := eventFilterOf(r)
+		if eventFilter.Limit == 0 {
+			eventFilter.Limit = 100
+		}
+
+		s.assets.Load().status.Execute(w, StatusPageModel{
 			Active:         s.core.ActiveContainers(),
 			Qualifying:     qualifying,
 			Providers:      providers,
+			LabelProblems:  labelProblems,
+			DryRun:         config.Current().DryRun,
+			DryRunActions:  s.core.DryRunActions(50),
+			Events:         s.core.Events(eventFilter),
+			Usage:          s.core.Usage(r.URL.Query().Get("by")),
+			UsageBy:        r.URL.Query().Get("by"),
+			EventFilter:    eventFilter,
+			EventTypes:     eventTypes,
+			ConfigError:    s.configError(),
 			RuntimeMetrics: fmt.Sprintf("Heap=%d, InUse=%d, Total=%d, Sys=%d, NumGC=%d", stats.HeapAlloc, stats.HeapInuse, stats.TotalAlloc, stats.Sys, stats.NumGC),
 		})
+	case "/api/events":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.core.Events(eventFilterOf(r))); err != nil {
+			logrus.Warnf("Error writing events: %v", err)
+		}
+	case "/api/explain":
+		host := r.URL.Query().Get("host")
+		if host == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, "missing host")
+			return
+		}
+		explanation, err := service.Explain(r.Context(), s.discovery, host)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(explanation); err != nil {
+			logrus.Warnf("Error writing explanation: %v", err)
+		}
+	case "/api/usage":
+		report := s.core.Usage(r.URL.Query().Get("by"))
+		var err error
+		if r.URL.Query().Get("format") == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+			err = service.WriteUsageCSV(w, report)
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(report)
+		}
+		if err != nil {
+			logrus.Warnf("Error writing usage: %v", err)
+		}
 	default:
 		w.WriteHeader(http.StatusNotFound)
 		io.WriteString(w, "Status page not found")
 	}
 }
+
+// Event filter from the container, type and limit query parameters
+func eventFilterOf(r *http.Request) service.EventFilter {
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	return service.EventFilter{
+		Container: query.Get("container"),
+		Type:      service.EventType(query.Get("type")),
+		Limit:     limit,
+	}
+}
+
+// Whether the http-provider config is served on host: the provider host, or else the status host
+// (with or without a port)
+func isProviderHost(host string) bool {
+	cfg := config.Current()
+	want := cfg.ProviderHost
+	if want == "" {
+		want = cfg.StatusHost
+	}
+	if want == "" {
+		return false
+	}
+	hostname, _, err := net.SplitHostPort(host)
+	return host == want || (err == nil && hostname == want)
+}
+
+// Serves traefik dynamic config (for the http provider) with a fallback router per qualifying container
+func (s *controller) ProviderHandler(w http.ResponseWriter, r *http.Request) {
+	if !isProviderHost(r.Host) {
+		s.ContainerHandler(w, r)
+		return
+	}
+
+	qualifying, err := s.discovery.QualifyingContainers(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, err.Error())
+		return
+	}
+
+	cfg := traefik.NewDynamicConfig()
+	for _, ct := range qualifying {
+		hosts, patterns, err := s.discovery.ContainerHosts(r.Context(), ct.ID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, err.Error())
+			return
+		}
+		if len(hosts) == 0 && len(patterns) == 0 {
+			logrus.Debugf("No hosts for %s, skipping provider router", ct.NameID())
+			continue
+		}
+
+		cfg.AddRouter("lazyload-"+ct.Name(), traefik.RouterConfig{
+			Rule:        traefik.HostRule(hosts, patterns),
+			Service:     config.Current().ProviderService,
+			Priority:    config.Current().ProviderPriority,
+			EntryPoints: config.Current().ProviderEntrypoints,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(cfg); err != nil {
+		logrus.Error(err)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorPageHost(t *testing.T) {
+	r := httptest.NewRequest("GET", "/__llerror?status=503&url="+url.QueryEscape("http://app.example.com:8080/path"), nil)
+	assert.Equal(t, "app.example.com", errorPageHost(r))
+
+	r = httptest.NewRequest("GET", "/__llerror?status=503", nil)
+	r.Header.Set("X-Forwarded-Host", "app.example.com:8443")
+	assert.Equal(t, "app.example.com", errorPageHost(r))
+
+	r = httptest.NewRequest("GET", "/__llerror?status=503", nil)
+	r.Host = "app.example.com:8080"
+	assert.Equal(t, "app.example.com", errorPageHost(r))
+
+	r.Host = "app.example.com"
+	assert.Equal(t, "app.example.com", errorPageHost(r))
+}
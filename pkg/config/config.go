@@ -1,11 +1,17 @@
 package config
 
 import (
+	"bytes"
 	_ "embed"
+	"errors"
+	"fmt"
+	"reflect"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
@@ -14,6 +20,7 @@ import (
 type ConfigModel struct {
 	Listen     string // http listen
 	StopAtBoot bool   // Stop existing containers at start of app
+	StopAtExit bool   // Stop managed containers when the app exits
 	Splash     string // Which splash page to serve
 	StatusHost string // Host that will serve the status page (empty is disabled)
 
@@ -21,14 +28,75 @@ type ConfigModel struct {
 	PollFreq  time.Duration // How often to check for changes
 	Timeout   time.Duration // Default operation timeout (eg. starting/stopping a container)
 
+	ShutdownTimeout time.Duration // How long to wait for requests and in-flight starts on shutdown
+
+	TraefikAPI    string // Base URL of the traefik API to resolve routes from (empty is disabled)
+	TraefikConfig string // Traefik file-provider config file or directory to resolve routes from (empty is disabled)
+
+	ProviderService     string   // Traefik service of the lazyloader; enables the traefik http-provider endpoint (empty is disabled)
+	ProviderPriority    int      // Priority of routers generated for the http-provider
+	ProviderEntrypoints []string // Entrypoints of routers generated for the http-provider (empty is all)
+	ProviderHost        string   // Host the http-provider config is served on (empty is the status host)
+
+	AccessLog             string   // Traefik JSON access log to read request activity from (empty is disabled)
+	AccessLogIgnoreAgents []string // User-agent substrings whose requests aren't activity
+	AccessLogIgnoreIPs    []string // Client IPs/CIDRs whose requests aren't activity
+
+	TraefikMetrics string // Traefik prometheus endpoint to read per-service request activity from each poll (empty is disabled)
+
+	ForwardAuth bool // Serve the traefik forwardAuth endpoint
+	ErrorPages  bool // Serve the traefik errors-middleware endpoint
+	Heartbeat   bool // Serve the endpoint containers can report their own activity to
+
+	ProcRoot     string // Host's procfs (needs the host pid namespace, eg. /proc or /host/proc) to read container stats from (empty is disabled)
+	CgroupRoot   string // Host's cgroup v2 filesystem, used with ProcRoot
+	StatsWorkers int    // How many containers' stats to collect at once
+
+	EventHistory int    // How many lifecycle events to keep in memory
+	EventLog     string // File to append lifecycle events to, as JSON lines (empty is disabled)
+
+	UsageFile string // File to persist usage accounting to, across restarts (empty is in-memory only)
+
+	DryRun bool // Only log and record the containers that would be started and stopped
+
 	Verbose bool // Debug-level logging
 
 	LabelPrefix string
 }
 
-var Model *ConfigModel = new(ConfigModel)
+// The current config. It's replaced as a whole on reload, never modified in place
+var current atomic.Pointer[ConfigModel]
+
+func init() {
+	current.Store(new(ConfigModel))
+}
+
+// Current returns the config in use. It must not be modified
+func Current() *ConfigModel {
+	return current.Load()
+}
+
+// Apply replaces the config in use
+func Apply(next *ConfigModel) {
+	current.Store(next)
+}
+
+// Update applies a copy of the current config, changed by fn
+func Update(fn func(next *ConfigModel)) {
+	next := *Current()
+	fn(&next)
+	Apply(&next)
+}
+
+// Settings only read at startup, so changing them needs a restart
+var restartFields = []string{
+	"Listen", "LabelPrefix", "TraefikAPI", "TraefikConfig", "ProviderService", "AccessLog", "TraefikMetrics",
+	"ForwardAuth", "ErrorPages", "Heartbeat", "ProcRoot", "CgroupRoot", "EventHistory", "EventLog", "UsageFile",
+}
 
-func Load() {
+// Load reads the config from (in order of precedence) flags, TLL_ environment variables, the
+// config file and the defaults. The config file is optional, unless set with --config
+func Load(flags *pflag.FlagSet) error {
 	viper.AddConfigPath(".")
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
@@ -37,15 +105,150 @@ func Load() {
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
 
+	defaults, err := loadDefaults()
+	if err != nil {
+		return err
+	}
+	for _, key := range defaults.AllKeys() {
+		viper.SetDefault(key, defaults.Get(key))
+	}
+
+	if flags != nil {
+		if path, _ := flags.GetString("config"); path != "" {
+			viper.SetConfigFile(path)
+		}
+		for _, name := range fieldNames() {
+			if flag := flags.Lookup(name); flag != nil {
+				if err := viper.BindPFlag(name, flag); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	next, err := Read()
+	if err != nil {
+		return err
+	}
+	Apply(next)
+	return nil
+}
+
+// Read re-reads and validates the config, without applying it
+func Read() (*ConfigModel, error) {
 	if err := viper.ReadInConfig(); err != nil {
-		logrus.Fatal(err)
+		var notFound viper.ConfigFileNotFoundError
+		if !errors.As(err, &notFound) {
+			return nil, err
+		}
+		logrus.Debug("No config file found, using defaults")
+	}
+
+	next := new(ConfigModel)
+	if err := viper.Unmarshal(next); err != nil {
+		return nil, err
+	}
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// AddFlags adds a flag for every setting (eg. --stopdelay), and --config for the config file
+func AddFlags(flags *pflag.FlagSet) {
+	flags.String("config", "", "Config file (default ./config.yaml, if it exists)")
+
+	// Errors are caught by Load; here they'd only hide the defaults in the help
+	defaults, _ := loadDefaults()
+	if defaults == nil {
+		defaults = viper.New()
+	}
+
+	modelType := reflect.TypeOf(ConfigModel{})
+	for i := range modelType.NumField() {
+		field := modelType.Field(i)
+		name := strings.ToLower(field.Name)
+		usage := fmt.Sprintf("Overrides %s from the config", name)
+
+		switch {
+		case field.Type == reflect.TypeOf(time.Duration(0)):
+			flags.Duration(name, defaults.GetDuration(name), usage)
+		case field.Type == reflect.TypeOf([]string(nil)):
+			flags.StringSlice(name, defaults.GetStringSlice(name), usage)
+		case field.Type.Kind() == reflect.Bool:
+			flags.Bool(name, defaults.GetBool(name), usage)
+		case field.Type.Kind() == reflect.Int:
+			flags.Int(name, defaults.GetInt(name), usage)
+		default:
+			flags.String(name, defaults.GetString(name), usage)
+		}
+	}
+}
+
+// Config keys, the lowercase field names
+func fieldNames() []string {
+	modelType := reflect.TypeOf(ConfigModel{})
+	ret := make([]string, 0, modelType.NumField())
+	for i := range modelType.NumField() {
+		ret = append(ret, strings.ToLower(modelType.Field(i).Name))
 	}
+	return ret
+}
+
+//go:embed defaults.yaml
+var defaultConfig []byte
+
+func loadDefaults() (*viper.Viper, error) {
+	defaults := viper.New()
+	defaults.SetConfigType("yaml")
+	if err := defaults.ReadConfig(bytes.NewReader(defaultConfig)); err != nil {
+		return nil, fmt.Errorf("reading default config: %w", err)
+	}
+	return defaults, nil
+}
+
+// Validate checks for values that can't work
+func (s *ConfigModel) Validate() error {
+	switch {
+	case s.LabelPrefix == "":
+		return errors.New("labelprefix must be set")
+	case s.PollFreq <= 0:
+		return fmt.Errorf("pollfreq must be positive, not %s", s.PollFreq)
+	case s.Timeout <= 0:
+		return fmt.Errorf("timeout must be positive, not %s", s.Timeout)
+	case s.StopDelay < 0:
+		return fmt.Errorf("stopdelay can't be negative, not %s", s.StopDelay)
+	case s.ShutdownTimeout < 0:
+		return fmt.Errorf("shutdowntimeout can't be negative, not %s", s.ShutdownTimeout)
+	case s.StatsWorkers < 0 || s.EventHistory < 0:
+		return errors.New("statsworkers and eventhistory can't be negative")
+	case s.ProviderService != "" && s.ProviderHost == "" && s.StatusHost == "":
+		return errors.New("providerservice needs a providerhost (or statushost) to serve the config on")
+	}
+	return nil
+}
+
+// RestartRequired lists the settings that differ between two configs, but only apply after a restart
+func RestartRequired(old, next *ConfigModel) []string {
+	var ret []string
+	oldVal, nextVal := reflect.ValueOf(old).Elem(), reflect.ValueOf(next).Elem()
+	for _, field := range restartFields {
+		if !reflect.DeepEqual(oldVal.FieldByName(field).Interface(), nextVal.FieldByName(field).Interface()) {
+			ret = append(ret, strings.ToLower(field))
+		}
+	}
+	return ret
+}
 
-	if err := viper.Unmarshal(Model); err != nil {
-		logrus.Fatal(err)
+// KeepRestartFields copies the settings that only apply after a restart from old into next, so a
+// reloaded config doesn't change them while running
+func KeepRestartFields(old, next *ConfigModel) {
+	oldVal, nextVal := reflect.ValueOf(old).Elem(), reflect.ValueOf(next).Elem()
+	for _, field := range restartFields {
+		nextVal.FieldByName(field).Set(oldVal.FieldByName(field))
 	}
 }
 
 func SubLabel(name string) string {
-	return Model.LabelPrefix + "." + name
+	return Current().LabelPrefix + "." + name
 }
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+func validModel() *ConfigModel {
+	return &ConfigModel{
+		Listen:      ":8080",
+		LabelPrefix: "lazyloader",
+		StopDelay:   5 * time.Minute,
+		PollFreq:    10 * time.Second,
+		Timeout:     30 * time.Second,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, validModel().Validate())
+
+	invalid := validModel()
+	invalid.PollFreq = 0
+	assert.ErrorContains(t, invalid.Validate(), "pollfreq")
+
+	invalid = validModel()
+	invalid.StopDelay = -time.Second
+	assert.ErrorContains(t, invalid.Validate(), "stopdelay")
+
+	invalid = validModel()
+	invalid.LabelPrefix = ""
+	assert.Error(t, invalid.Validate())
+
+	invalid = validModel()
+	invalid.ProviderService = "lazyload@docker"
+	assert.ErrorContains(t, invalid.Validate(), "providerhost")
+	invalid.ProviderHost = "lazyloader"
+	assert.NoError(t, invalid.Validate())
+}
+
+func TestRestartRequired(t *testing.T) {
+	old, next := validModel(), validModel()
+	next.StopDelay = time.Minute
+	next.Splash = "other.html"
+	assert.Empty(t, RestartRequired(old, next))
+
+	next.Listen = ":9090"
+	next.AccessLog = "/var/log/access.log"
+	assert.Equal(t, []string{"listen", "accesslog"}, RestartRequired(old, next))
+}
+
+func TestLoadWithoutConfigFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	AddFlags(flags)
+	assert.NoError(t, flags.Parse([]string{"--stopdelay", "1m", "--accesslogignoreips", "10.0.0.0/8,::1"}))
+
+	assert.NoError(t, Load(flags))
+	assert.Equal(t, time.Minute, Current().StopDelay)
+	assert.Equal(t, []string{"10.0.0.0/8", "::1"}, Current().AccessLogIgnoreIPs)
+	assert.Equal(t, 10*time.Second, Current().PollFreq) // From the defaults
+	assert.Equal(t, "lazyloader", Current().LabelPrefix)
+}
+
+func TestDefaultsCoverAllFields(t *testing.T) {
+	defaults, err := loadDefaults()
+	assert.NoError(t, err)
+	for _, name := range fieldNames() {
+		assert.True(t, defaults.IsSet(name), name)
+	}
+}
+
+func TestKeepRestartFields(t *testing.T) {
+	old, next := validModel(), validModel()
+	next.Listen = ":9000"
+	next.StopDelay = time.Hour
+
+	KeepRestartFields(old, next)
+	assert.Equal(t, old.Listen, next.Listen)
+	assert.Equal(t, time.Hour, next.StopDelay)
+}
@@ -2,20 +2,35 @@ package containers
 
 import (
 	"context"
-	"regexp"
-	"strings"
+	"net"
+	"sync"
+	"time"
 	"traefik-lazyload/pkg/config"
 
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/sirupsen/logrus"
 )
 
 type Discovery struct {
 	client Host
+
+	sources []RouteSource
+
+	mux      sync.RWMutex
+	index    *hostIndex // nil until first needed
+	buildMux sync.Mutex // Serializes building the index, so concurrent lookups share one build
 }
 
 func NewDiscovery(client Host) *Discovery {
-	return &Discovery{client}
+	return &Discovery{client: client}
+}
+
+// AddRouteSource registers an additional source of routes. Routes from sources take
+// precedence over container labels, which remain the fallback
+func (s *Discovery) AddRouteSource(src RouteSource) {
+	s.sources = append(s.sources, src)
 }
 
 // Return all containers that qualify to be load-managed (eg. have the tag)
@@ -35,7 +50,7 @@ func (s *Discovery) ProviderContainers(ctx context.Context) ([]Wrapper, error) {
 
 func (s *Discovery) FindAllLazyload(ctx context.Context, includeStopped bool) ([]Wrapper, error) {
 	filters := filters.NewArgs()
-	filters.Add("label", config.Model.LabelPrefix)
+	filters.Add("label", config.Current().LabelPrefix)
 
 	return wrapListResult(s.client.ContainerList(ctx, container.ListOptions{
 		All:     includeStopped,
@@ -43,102 +58,182 @@ func (s *Discovery) FindAllLazyload(ctx context.Context, includeStopped bool) ([
 	}))
 }
 
+// Find the container that serves a hostname, using the host index (built on demand)
 func (s *Discovery) FindContainerByHostname(ctx context.Context, hostname string) (*Wrapper, error) {
-	containers, err := s.FindAllLazyload(ctx, true)
+	idx, err := s.hostIndex(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, c := range containers {
-		if hostStr, ok := c.Config("hosts"); ok {
-			hosts := strings.Split(hostStr, ",")
-			if strSliceContains(hosts, hostname) {
-				return &c, nil
-			}
-		} else if matchesTraefikRule(&c, hostname) {
-			// If not defined explicitly, infer from traefik route
-			return &c, nil
-		}
+	if ct, ok := idx.Lookup(hostname); ok {
+		ret := *ct
+		return &ret, nil
 	}
 
 	return nil, ErrNotFound
 }
 
-// matchesTraefikRule checks if hostname matches any Traefik router rule
-func matchesTraefikRule(c *Wrapper, hostname string) bool {
-	for k, v := range c.Labels {
-		if strings.Contains(k, "traefik.http.routers.") && strings.HasSuffix(k, ".rule") {
-			if matchesTraefikRuleValue(v, hostname) {
-				return true
+// Find the container serving a request seen by traefik, by host or else by router/service name
+// (eg. from the access log). Names may include an @provider suffix
+func (s *Discovery) FindContainerByRequest(ctx context.Context, hostname, router, service string) (*Wrapper, error) {
+	idx, err := s.hostIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if hostname != "" {
+		if ct, ok := idx.Lookup(hostname); ok {
+			ret := *ct
+			return &ret, nil
+		}
+		if host, _, err := net.SplitHostPort(hostname); err == nil {
+			if ct, ok := idx.Lookup(host); ok {
+				ret := *ct
+				return &ret, nil
 			}
 		}
 	}
-	return false
+
+	if ct, ok := idx.LookupTraefikName(TrimProvider(router), TrimProvider(service)); ok {
+		ret := *ct
+		return &ret, nil
+	}
+
+	return nil, ErrNotFound
+}
+
+// ContainerHosts returns the exact hosts and host regexps that route to a container
+func (s *Discovery) ContainerHosts(ctx context.Context, cid string) (hosts, patterns []string, err error) {
+	idx, err := s.hostIndex(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	hosts, patterns = idx.Hosts(cid)
+	return hosts, patterns, nil
+}
+
+// UpdateIndex rebuilds the host index from a full (including stopped) set of lazyload containers
+func (s *Discovery) UpdateIndex(ctx context.Context, cts []Wrapper) {
+	s.buildMux.Lock()
+	defer s.buildMux.Unlock()
+	s.updateIndexLocked(ctx, cts)
+}
+
+// RefreshIndex rebuilds the host index from a new container listing. Lookups meanwhile use the
+// current index, so they never wait on docker
+func (s *Discovery) RefreshIndex(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().Timeout)
+	defer cancel()
+
+	s.buildMux.Lock()
+	defer s.buildMux.Unlock()
+	if _, err := s.rebuildIndexLocked(ctx); err != nil {
+		logrus.Warnf("Unable to refresh host index, keeping the current one: %v", err)
+	}
 }
 
-// matchesTraefikRuleValue parses Traefik rule syntax and checks if hostname matches
-func matchesTraefikRuleValue(rule string, hostname string) bool {
-	// Check Host() matchers
-	if matchesHostMatcher(rule, hostname) {
-		return true
+func (s *Discovery) rebuildIndexLocked(ctx context.Context) (*hostIndex, error) {
+	cts, err := s.FindAllLazyload(ctx, true)
+	if err != nil {
+		return nil, err
 	}
+	return s.updateIndexLocked(ctx, cts), nil
+}
 
-	// Check HostRegexp() matchers
-	if matchesHostRegexpMatcher(rule, hostname) {
-		return true
+func (s *Discovery) updateIndexLocked(ctx context.Context, cts []Wrapper) *hostIndex {
+	idx := newHostIndex()
+	for _, src := range s.sources {
+		routes, err := src.Routes(ctx)
+		if err != nil {
+			logrus.Warnf("Unable to get routes from source, falling back to labels: %v", err)
+			continue
+		}
+		idx.addRoutes(routes, cts)
 	}
+	idx.addContainers(cts)
 
-	return false
+	s.mux.Lock()
+	s.index = idx
+	s.mux.Unlock()
+	return idx
 }
 
-// matchesHostMatcher checks if hostname matches Host() directive
-func matchesHostMatcher(rule string, hostname string) bool {
-	hostPattern := regexp.MustCompile(`Host\((.*?)\)`)
-	matches := hostPattern.FindAllStringSubmatch(rule, -1)
-	for _, match := range matches {
-		if len(match) > 1 {
-			hosts := extractBacktickValues(match[1])
-			for _, host := range hosts {
-				if host == hostname {
-					return true
-				}
-			}
+// The host index, built on first use. Later changes are picked up by RefreshIndex and UpdateIndex
+func (s *Discovery) hostIndex(ctx context.Context) (*hostIndex, error) {
+	s.mux.RLock()
+	idx := s.index
+	s.mux.RUnlock()
+	if idx != nil {
+		return idx, nil
+	}
+
+	s.buildMux.Lock()
+	defer s.buildMux.Unlock()
+	s.mux.RLock()
+	idx = s.index
+	s.mux.RUnlock()
+	if idx != nil { // Built while waiting
+		return idx, nil
+	}
+	return s.rebuildIndexLocked(ctx)
+}
+
+// WatchEvents listens to docker container events and rebuilds the host index when a lazyload
+// container changes. Events arriving during a rebuild are coalesced into a single next one, so a
+// burst (eg. a compose up) doesn't queue a listing per event. Blocks until ctx is cancelled
+func (s *Discovery) WatchEvents(ctx context.Context) {
+	const retryDelay = 5 * time.Second
+
+	dirty := make(chan struct{}, 1)
+	markDirty := func() {
+		select {
+		case dirty <- struct{}{}:
+		default: // A rebuild is already pending
 		}
 	}
-	return false
-}
-
-// matchesHostRegexpMatcher checks if hostname matches HostRegexp() directive
-func matchesHostRegexpMatcher(rule string, hostname string) bool {
-	hostRegexpPattern := regexp.MustCompile(`HostRegexp\((.*?)\)`)
-	matches := hostRegexpPattern.FindAllStringSubmatch(rule, -1)
-	for _, match := range matches {
-		if len(match) > 1 {
-			patterns := extractBacktickValues(match[1])
-			for _, pattern := range patterns {
-				if re, err := regexp.Compile(pattern); err == nil {
-					if re.MatchString(hostname) {
-						return true
-					}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-dirty:
+				s.RefreshIndex(ctx)
+			}
+		}
+	}()
+
+	filters := filters.NewArgs()
+	filters.Add("type", string(events.ContainerEventType))
+	filters.Add("label", config.Current().LabelPrefix)
+
+	for {
+		msgs, errs := s.client.Events(ctx, events.ListOptions{Filters: filters})
+
+	watch:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-msgs:
+				switch msg.Action {
+				case events.ActionCreate, events.ActionDestroy, events.ActionRename, events.ActionUpdate,
+					events.ActionStart, events.ActionDie:
+					logrus.Debugf("Container event %s on %s, rebuilding host index", msg.Action, msg.Actor.ID)
+					markDirty()
 				}
+			case err := <-errs:
+				logrus.Warnf("Error watching docker events, retrying in %s: %v", retryDelay, err)
+				markDirty() // may have missed events
+				break watch
 			}
 		}
-	}
-	return false
-}
-
-// extractBacktickValues extracts values from backtick-quoted strings
-// e.g., "`a.com`, `b.com`" -> ["a.com", "b.com"]
-func extractBacktickValues(s string) []string {
-	re := regexp.MustCompile("`([^`]+)`")
-	matches := re.FindAllStringSubmatch(s, -1)
-	result := make([]string, 0, len(matches))
-	for _, match := range matches {
-		if len(match) > 1 {
-			result = append(result, match[1])
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryDelay):
 		}
 	}
-	return result
 }
 
 func (s *Discovery) FindDepProvider(ctx context.Context, name string) ([]Wrapper, error) {
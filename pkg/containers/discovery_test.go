@@ -138,12 +138,8 @@ func TestMatchesTraefikRule(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			wrapper := &Wrapper{
-				Summary: container.Summary{
-					Labels: tt.labels,
-				},
-			}
-			result := matchesTraefikRule(wrapper, tt.hostname)
+			idx := buildHostIndex([]Wrapper{{Summary: container.Summary{ID: "a", Labels: tt.labels}}})
+			_, result := idx.Lookup(tt.hostname)
 			assert.Equal(t, tt.expected, result)
 		})
 	}
@@ -208,7 +204,8 @@ func TestMatchesHostMatcher(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := matchesHostMatcher(tt.rule, tt.hostname)
+			hosts, _ := parseTraefikRuleHosts(tt.rule)
+			_, result := matcherIndex(hosts, nil).Lookup(tt.hostname)
 			assert.Equal(t, tt.expected, result)
 		})
 	}
@@ -285,12 +282,41 @@ func TestMatchesHostRegexpMatcher(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := matchesHostRegexpMatcher(tt.rule, tt.hostname)
+			_, patterns := parseTraefikRuleHosts(tt.rule)
+			_, result := matcherIndex(nil, patterns).Lookup(tt.hostname)
 			assert.Equal(t, tt.expected, result)
 		})
 	}
 }
 
+// An index of a single container with only the given matchers
+func matcherIndex(hosts, patterns []string) *hostIndex {
+	idx := newHostIndex()
+	idx.addMatchers(&Wrapper{Summary: container.Summary{ID: "a"}}, hosts, patterns)
+	return idx
+}
+
+// Unlike matching containers in order, an exact host wins over an earlier container's regexp
+func TestMatchesHostBeforeHostRegexp(t *testing.T) {
+	idx := buildHostIndex([]Wrapper{
+		{Summary: container.Summary{ID: "a", Labels: map[string]string{
+			"traefik.http.routers.a.rule": "HostRegexp(`^[a-z]+\\.example\\.com$`)",
+		}}},
+		{Summary: container.Summary{ID: "b", Labels: map[string]string{
+			"traefik.http.routers.b.rule": "Host(`web.example.com`)",
+		}}},
+	})
+
+	ct, ok := idx.Lookup("web.example.com")
+	if assert.True(t, ok) {
+		assert.Equal(t, "b", ct.ID)
+	}
+	ct, ok = idx.Lookup("api.example.com")
+	if assert.True(t, ok) {
+		assert.Equal(t, "a", ct.ID)
+	}
+}
+
 func TestExtractBacktickValues(t *testing.T) {
 	tests := []struct {
 		name     string
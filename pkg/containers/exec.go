@@ -0,0 +1,39 @@
+package containers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Exec runs a command in a running container, returning its stdout, stderr and exit code
+func Exec(ctx context.Context, host Host, cid string, cmd []string) (stdout, stderr string, exitCode int, err error) {
+	created, err := host.ContainerExecCreate(ctx, cid, container.ExecOptions{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return "", "", -1, err
+	}
+
+	attached, err := host.ContainerExecAttach(ctx, created.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return "", "", -1, err
+	}
+	defer attached.Close()
+
+	var outBuf, errBuf bytes.Buffer
+	if _, err := stdcopy.StdCopy(&outBuf, &errBuf, attached.Reader); err != nil {
+		return "", "", -1, fmt.Errorf("reading exec output: %w", err)
+	}
+
+	inspect, err := host.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		return outBuf.String(), errBuf.String(), -1, err
+	}
+	return outBuf.String(), errBuf.String(), inspect.ExitCode, nil
+}
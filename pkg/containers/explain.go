@@ -0,0 +1,188 @@
+package containers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"traefik-lazyload/pkg/config"
+)
+
+// Kinds of host matcher
+const (
+	MatcherHost   = "host"
+	MatcherRegexp = "regexp"
+)
+
+// RuleCheck is a single host matcher checked against a hostname
+type RuleCheck struct {
+	Source  string `json:"source"`          // Where the matcher came from, eg. lazyloader.hosts or a router rule label
+	Kind    string `json:"kind,omitempty"`  // MatcherHost or MatcherRegexp (empty if there was nothing to match)
+	Value   string `json:"value,omitempty"` // Host or regexp
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason"`
+}
+
+// ContainerCheck is a qualifying container considered for a hostname
+type ContainerCheck struct {
+	ID        string      `json:"id"`
+	Container string      `json:"container"`
+	Matched   bool        `json:"matched"` // Whether any of its rules matched
+	Rules     []RuleCheck `json:"rules"`
+}
+
+// RouteExplanation is how a hostname resolves to a container, step by step
+type RouteExplanation struct {
+	Hostname   string           `json:"hostname"`
+	Candidates []ContainerCheck `json:"candidates"`
+	Winner     *Wrapper         `json:"-"`
+	WinnerName string           `json:"winner,omitempty"`
+	Why        string           `json:"why"`               // Why the winner won, or nothing did
+	Skipped    []string         `json:"skipped,omitempty"` // Route sources that couldn't be read, as in the index
+}
+
+// HostnameOf returns the hostname of a URL, or the input if it isn't one, without any port
+func HostnameOf(hostOrURL string) string {
+	if strings.Contains(hostOrURL, "://") {
+		if u, err := url.Parse(hostOrURL); err == nil && u.Host != "" {
+			return u.Hostname()
+		}
+	}
+	host := strings.TrimSuffix(hostOrURL, "/")
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		return hostname
+	}
+	return host
+}
+
+// Explain checks a hostname against every matcher of every qualifying container, in the order
+// the host index uses them: route sources, then `hosts` labels or router rule labels
+func (s *Discovery) Explain(ctx context.Context, hostname string) (*RouteExplanation, error) {
+	cts, err := s.FindAllLazyload(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := s.hostIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	checks := make(map[string]*ContainerCheck, len(cts))
+	ret := &RouteExplanation{Hostname: hostname}
+	for i := range cts {
+		ret.Candidates = append(ret.Candidates, ContainerCheck{ID: cts[i].ID, Container: cts[i].NameID()})
+	}
+	for i := range ret.Candidates {
+		checks[ret.Candidates[i].ID] = &ret.Candidates[i]
+	}
+	add := func(ct *Wrapper, rule RuleCheck) {
+		check := checks[ct.ID]
+		check.Rules = append(check.Rules, rule)
+		check.Matched = check.Matched || rule.Matched
+	}
+
+	for _, src := range s.sources {
+		routes, err := src.Routes(ctx)
+		if err != nil {
+			ret.Skipped = append(ret.Skipped, err.Error())
+			continue
+		}
+		for i := range routes {
+			ct := resolveRouteContainer(&routes[i], cts)
+			if ct == nil {
+				continue
+			}
+			source := "router " + routes[i].Router + "@" + routes[i].Provider
+			for _, rule := range explainRule(source, routes[i].Rule, hostname) {
+				add(ct, rule)
+			}
+		}
+	}
+
+	for i := range cts {
+		ct := &cts[i]
+		if hostStr, ok := ct.Config("hosts"); ok {
+			for _, host := range strings.Split(hostStr, ",") {
+				add(ct, checkHost(config.SubLabel("hosts"), host, hostname))
+			}
+			continue
+		}
+
+		labels := make([]string, 0)
+		for label := range ct.Labels {
+			if isTraefikRuleLabel(label) {
+				labels = append(labels, label)
+			}
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			for _, rule := range explainRule(label, ct.Labels[label], hostname) {
+				add(ct, rule)
+			}
+		}
+		if len(labels) == 0 {
+			add(ct, RuleCheck{Source: "labels", Reason: "no hosts label or traefik router rules"})
+		}
+	}
+
+	if winner, ok := idx.Lookup(hostname); ok {
+		ret.Winner, ret.WinnerName = winner, winner.NameID()
+		if _, exact := idx.exact[hostname]; exact {
+			ret.Why = "exact host match; if several containers claim a host, the first (route sources, then labels) wins"
+		} else {
+			ret.Why = "first matching host regexp; there is no exact host match, which would take precedence"
+		}
+	} else {
+		ret.Why = "no container has a matching host or host regexp"
+	}
+	return ret, nil
+}
+
+// Checks each Host() and HostRegexp() value of a traefik rule
+func explainRule(source, rule, hostname string) []RuleCheck {
+	hosts, patterns := parseTraefikRuleHosts(rule)
+	if len(hosts) == 0 && len(patterns) == 0 {
+		return []RuleCheck{{Source: source, Reason: fmt.Sprintf("rule %q has no Host() or HostRegexp()", rule)}}
+	}
+
+	ret := make([]RuleCheck, 0, len(hosts)+len(patterns))
+	for _, host := range hosts {
+		ret = append(ret, checkHost(source, host, hostname))
+	}
+	for _, pattern := range patterns {
+		ret = append(ret, checkRegexp(source, pattern, hostname))
+	}
+	return ret
+}
+
+func checkHost(source, host, hostname string) RuleCheck {
+	ret := RuleCheck{Source: source, Kind: MatcherHost, Value: host, Matched: host == hostname}
+	switch {
+	case ret.Matched:
+		ret.Reason = "same host"
+	case strings.EqualFold(host, hostname):
+		ret.Reason = "differs in case; hosts are compared exactly"
+	default:
+		ret.Reason = "different host"
+	}
+	return ret
+}
+
+func checkRegexp(source, pattern, hostname string) RuleCheck {
+	ret := RuleCheck{Source: source, Kind: MatcherRegexp, Value: pattern}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		ret.Reason = fmt.Sprintf("invalid regexp, ignored: %v", err)
+		return ret
+	}
+	ret.Matched = re.MatchString(hostname)
+	if ret.Matched {
+		ret.Reason = "regexp matches"
+	} else {
+		ret.Reason = "regexp doesn't match"
+	}
+	return ret
+}
@@ -0,0 +1,94 @@
+package containers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"traefik-lazyload/pkg/config"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRouteSource struct {
+	routes []Route
+	err    error
+}
+
+func (s *fakeRouteSource) Routes(ctx context.Context) ([]Route, error) {
+	return s.routes, s.err
+}
+
+func TestExplain(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.LabelPrefix = "lazyloader" })
+
+	host := &fakeHost{containers: []container.Summary{
+		{ID: "a", Names: []string{"/a"}, Labels: map[string]string{
+			"lazyloader":                  "true",
+			"lazyloader.hosts":            "A.com,b.com",
+			"traefik.http.routers.a.rule": "Host(`ignored.com`)",
+		}},
+		{ID: "b", Names: []string{"/b"}, Labels: map[string]string{
+			"lazyloader":                  "true",
+			"traefik.http.routers.b.rule": "HostRegexp(`^[a-z]+\\.com$`) || PathPrefix(`/b`)",
+			"traefik.http.routers.c.rule": "PathPrefix(`/c`)",
+		}},
+		{ID: "c", Names: []string{"/c"}, Labels: map[string]string{"lazyloader": "true"}},
+	}}
+	d := NewDiscovery(host)
+
+	ex, err := d.Explain(context.Background(), "a.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "b (b)", ex.WinnerName)
+	assert.Contains(t, ex.Why, "regexp")
+
+	assert.Equal(t, []ContainerCheck{
+		{ID: "a", Container: "a (a)", Rules: []RuleCheck{
+			{Source: "lazyloader.hosts", Kind: MatcherHost, Value: "A.com", Reason: "differs in case; hosts are compared exactly"},
+			{Source: "lazyloader.hosts", Kind: MatcherHost, Value: "b.com", Reason: "different host"},
+		}},
+		{ID: "b", Container: "b (b)", Matched: true, Rules: []RuleCheck{
+			{Source: "traefik.http.routers.b.rule", Kind: MatcherRegexp, Value: "^[a-z]+\\.com$", Matched: true, Reason: "regexp matches"},
+			{Source: "traefik.http.routers.c.rule", Reason: "rule \"PathPrefix(`/c`)\" has no Host() or HostRegexp()"},
+		}},
+		{ID: "c", Container: "c (c)", Rules: []RuleCheck{
+			{Source: "labels", Reason: "no hosts label or traefik router rules"},
+		}},
+	}, ex.Candidates)
+
+	ex, err = d.Explain(context.Background(), "b.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "a (a)", ex.WinnerName)
+	assert.Contains(t, ex.Why, "exact")
+
+	ex, err = d.Explain(context.Background(), "none.net")
+	assert.NoError(t, err)
+	assert.Nil(t, ex.Winner)
+}
+
+func TestExplainRouteSources(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.LabelPrefix = "lazyloader" })
+
+	host := &fakeHost{containers: []container.Summary{
+		{ID: "a", Names: []string{"/a"}, Labels: map[string]string{"lazyloader": "true"}},
+	}}
+	d := NewDiscovery(host)
+	d.AddRouteSource(&fakeRouteSource{routes: []Route{
+		{Router: "web", Provider: "file", Rule: "Host(`a.com`)", Servers: []string{"http://a:80"}},
+	}})
+	d.AddRouteSource(&fakeRouteSource{err: errors.New("unreachable")})
+
+	ex, err := d.Explain(context.Background(), "a.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "a (a)", ex.WinnerName)
+	assert.Equal(t, []string{"unreachable"}, ex.Skipped)
+	assert.Equal(t, RuleCheck{Source: "router web@file", Kind: MatcherHost, Value: "a.com", Matched: true, Reason: "same host"}, ex.Candidates[0].Rules[0])
+}
+
+func TestHostnameOf(t *testing.T) {
+	assert.Equal(t, "a.com", HostnameOf("a.com"))
+	assert.Equal(t, "a.com", HostnameOf("https://a.com/path?q=1"))
+	assert.Equal(t, "a.com", HostnameOf("http://a.com:8080"))
+	assert.Equal(t, "a.com", HostnameOf("https://a.com:8443/path"))
+	assert.Equal(t, "a.com", HostnameOf("a.com:8080"))
+}
@@ -0,0 +1,210 @@
+package containers
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	hostMatcherPattern       = regexp.MustCompile(`Host\((.*?)\)`)
+	hostRegexpMatcherPattern = regexp.MustCompile(`HostRegexp\((.*?)\)`)
+	backtickValuePattern     = regexp.MustCompile("`([^`]+)`")
+)
+
+// hostIndex is a pre-computed hostname -> container lookup. It is built once from
+// a container listing, so resolving a request doesn't need to query docker or
+// compile any regexps
+type hostIndex struct {
+	exact       map[string]*Wrapper
+	regexps     []hostRegexp
+	byContainer map[string]*containerHosts // cid -> hosts that route to it
+	containers  []Wrapper                  // all indexed containers
+}
+
+type containerHosts struct {
+	hosts, patterns []string
+}
+
+type hostRegexp struct {
+	re *regexp.Regexp
+	ct *Wrapper
+}
+
+func newHostIndex() *hostIndex {
+	return &hostIndex{
+		exact:       make(map[string]*Wrapper),
+		byContainer: make(map[string]*containerHosts),
+	}
+}
+
+// Add the hosts of each container, as given by their labels. If multiple containers
+// claim the same host, the first one wins
+func (s *hostIndex) addContainers(cts []Wrapper) {
+	s.containers = cts
+	for i := range cts {
+		ct := &cts[i]
+		hosts, patterns := containerHostMatchers(ct)
+		s.addMatchers(ct, hosts, patterns)
+	}
+}
+
+// Add the hosts of each route that resolves to one of the containers
+func (s *hostIndex) addRoutes(routes []Route, cts []Wrapper) {
+	for i := range routes {
+		ct := resolveRouteContainer(&routes[i], cts)
+		if ct == nil {
+			continue
+		}
+		hosts, patterns := parseTraefikRuleHosts(routes[i].Rule)
+		s.addMatchers(ct, hosts, patterns)
+	}
+}
+
+func (s *hostIndex) addMatchers(ct *Wrapper, hosts, patterns []string) {
+	for _, host := range hosts {
+		s.addHost(host, ct)
+	}
+	for _, pattern := range patterns {
+		s.addRegexp(pattern, ct)
+	}
+}
+
+func (s *hostIndex) addHost(host string, ct *Wrapper) {
+	if _, exists := s.exact[host]; !exists {
+		s.exact[host] = ct
+		ch := s.containerHosts(ct.ID)
+		ch.hosts = append(ch.hosts, host)
+	}
+}
+
+func (s *hostIndex) addRegexp(pattern string, ct *Wrapper) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		logrus.Debugf("Ignoring invalid host regexp %q on %s: %v", pattern, ct.NameID(), err)
+		return
+	}
+	s.regexps = append(s.regexps, hostRegexp{re, ct})
+	ch := s.containerHosts(ct.ID)
+	ch.patterns = append(ch.patterns, pattern)
+}
+
+func (s *hostIndex) containerHosts(cid string) *containerHosts {
+	ch, ok := s.byContainer[cid]
+	if !ok {
+		ch = &containerHosts{}
+		s.byContainer[cid] = ch
+	}
+	return ch
+}
+
+// Hosts returns the exact hosts and host regexps that route to a container
+func (s *hostIndex) Hosts(cid string) (hosts, patterns []string) {
+	if ch, ok := s.byContainer[cid]; ok {
+		return ch.hosts, ch.patterns
+	}
+	return nil, nil
+}
+
+// Lookup a hostname. Exact hosts take precedence over regexps, whichever container comes first;
+// among several regexps (or containers claiming the same host), the first one added wins
+func (s *hostIndex) Lookup(hostname string) (*Wrapper, bool) {
+	if ct, ok := s.exact[hostname]; ok {
+		return ct, true
+	}
+	for _, hr := range s.regexps {
+		if hr.re.MatchString(hostname) {
+			return hr.ct, true
+		}
+	}
+	return nil, false
+}
+
+// LookupTraefikName finds the container that defines a traefik router or service (without @provider)
+func (s *hostIndex) LookupTraefikName(router, service string) (*Wrapper, bool) {
+	for i := range s.containers {
+		ct := &s.containers[i]
+		if (router != "" && ct.HasTraefikRouter(router)) || (service != "" && ct.HasTraefikService(service)) {
+			return ct, true
+		}
+	}
+	return nil, false
+}
+
+// containerHostMatchers returns the exact hosts and host regexps a container answers to.
+// Explicit `hosts` config takes precedence, otherwise they're inferred from the traefik router rules
+func containerHostMatchers(ct *Wrapper) (hosts, patterns []string) {
+	if hostStr, ok := ct.Config("hosts"); ok {
+		return strings.Split(hostStr, ","), nil
+	}
+
+	for _, rule := range traefikRouterRules(ct) {
+		h, p := parseTraefikRuleHosts(rule)
+		hosts = append(hosts, h...)
+		patterns = append(patterns, p...)
+	}
+	return
+}
+
+// traefikRouterRules returns all traefik router rules on a container, ordered by label
+func traefikRouterRules(ct *Wrapper) []string {
+	keys := make([]string, 0)
+	for k := range ct.Labels {
+		if isTraefikRuleLabel(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	rules := make([]string, len(keys))
+	for i, k := range keys {
+		rules[i] = ct.Labels[k]
+	}
+	return rules
+}
+
+// UnmatchableRouters returns the router rule labels without a Host() or HostRegexp() matcher, which
+// can't be routed to the container by hostname
+func UnmatchableRouters(ct *Wrapper) []string {
+	ret := make([]string, 0)
+	for label, rule := range ct.Labels {
+		if !isTraefikRuleLabel(label) {
+			continue
+		}
+		if hosts, patterns := parseTraefikRuleHosts(rule); len(hosts) == 0 && len(patterns) == 0 {
+			ret = append(ret, label)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func isTraefikRuleLabel(label string) bool {
+	return strings.Contains(label, "traefik.http.routers.") && strings.HasSuffix(label, ".rule")
+}
+
+// parseTraefikRuleHosts extracts the Host() and HostRegexp() values from a traefik rule
+func parseTraefikRuleHosts(rule string) (hosts, patterns []string) {
+	for _, match := range hostMatcherPattern.FindAllStringSubmatch(rule, -1) {
+		hosts = append(hosts, extractBacktickValues(match[1])...)
+	}
+	for _, match := range hostRegexpMatcherPattern.FindAllStringSubmatch(rule, -1) {
+		patterns = append(patterns, extractBacktickValues(match[1])...)
+	}
+	return
+}
+
+// extractBacktickValues extracts values from backtick-quoted strings
+// e.g., "`a.com`, `b.com`" -> ["a.com", "b.com"]
+func extractBacktickValues(s string) []string {
+	matches := backtickValuePattern.FindAllStringSubmatch(s, -1)
+	result := make([]string, 0, len(matches))
+	for _, match := range matches {
+		if len(match) > 1 {
+			result = append(result, match[1])
+		}
+	}
+	return result
+}
@@ -0,0 +1,170 @@
+package containers
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+	"traefik-lazyload/pkg/config"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/stretchr/testify/assert"
+)
+
+// Build an index from a set of (sorted) containers, by their labels only
+func buildHostIndex(cts []Wrapper) *hostIndex {
+	idx := newHostIndex()
+	idx.addContainers(cts)
+	return idx
+}
+
+func TestHostIndexLookup(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.LabelPrefix = "lazyloader" })
+
+	idx := buildHostIndex(wrapContainers(
+		container.Summary{ID: "a", Names: []string{"/a"}, Labels: map[string]string{
+			"lazyloader.hosts": "a.com,b.com",
+			// Explicit hosts override router rules
+			"traefik.http.routers.a.rule": "Host(`ignored.com`)",
+		}},
+		container.Summary{ID: "b", Names: []string{"/b"}, Labels: map[string]string{
+			"traefik.http.routers.b.rule": "Host(`b.com`, `c.com`)",
+		}},
+		container.Summary{ID: "c", Names: []string{"/c"}, Labels: map[string]string{
+			"traefik.http.routers.c.rule": "HostRegexp(`^[a-z]+\\.example\\.com$`) || HostRegexp(`^[invalid(regex$`)",
+		}},
+		container.Summary{ID: "d", Names: []string{"/d"}, Labels: map[string]string{
+			"traefik.http.routers.d.rule": "Host(`exact.example.com`)",
+		}},
+	))
+
+	tests := []struct {
+		hostname string
+		expected string
+	}{
+		{"a.com", "a"},
+		{"b.com", "a"}, // first container wins
+		{"c.com", "b"},
+		{"ignored.com", ""},
+		{"test.example.com", "c"},
+		{"exact.example.com", "d"}, // exact before regexp
+		{"test1.example.com", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hostname, func(t *testing.T) {
+			ct, ok := idx.Lookup(tt.hostname)
+			if tt.expected == "" {
+				assert.False(t, ok)
+			} else if assert.True(t, ok) {
+				assert.Equal(t, tt.expected, ct.ID)
+			}
+		})
+	}
+}
+
+func TestHostIndexHosts(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.LabelPrefix = "lazyloader" })
+
+	idx := buildHostIndex(wrapContainers(
+		container.Summary{ID: "a", Names: []string{"/a"}, Labels: map[string]string{
+			"lazyloader.hosts": "a.com,b.com",
+		}},
+		container.Summary{ID: "b", Names: []string{"/b"}, Labels: map[string]string{
+			"traefik.http.routers.b.rule": "Host(`b.com`) || HostRegexp(`^b[0-9]+\\.com$`)",
+		}},
+	))
+
+	hosts, patterns := idx.Hosts("a")
+	assert.Equal(t, []string{"a.com", "b.com"}, hosts)
+	assert.Empty(t, patterns)
+
+	hosts, patterns = idx.Hosts("b")
+	assert.Empty(t, hosts) // b.com is claimed by a
+	assert.Equal(t, []string{`^b[0-9]+\.com$`}, patterns)
+
+	hosts, patterns = idx.Hosts("c")
+	assert.Nil(t, hosts)
+	assert.Nil(t, patterns)
+}
+
+func TestFindContainerByHostnameUsesIndex(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.LabelPrefix = "lazyloader" })
+
+	host := &fakeHost{containers: []container.Summary{
+		{ID: "a", Labels: map[string]string{"lazyloader": "true", "lazyloader.hosts": "a.com"}},
+	}}
+	d := NewDiscovery(host)
+	ctx := context.Background()
+
+	ct, err := d.FindContainerByHostname(ctx, "a.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", ct.ID)
+
+	_, err = d.FindContainerByHostname(ctx, "b.com")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 1, host.listCalls)
+
+	// Refreshed from a new listing; lookups keep using the index
+	host.containers[0].Labels["lazyloader.hosts"] = "b.com"
+	d.RefreshIndex(ctx)
+	_, err = d.FindContainerByHostname(ctx, "b.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, host.listCalls)
+}
+
+func TestHostIndexBuiltOnce(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.LabelPrefix = "lazyloader" })
+
+	host := &fakeHost{containers: []container.Summary{
+		{ID: "a", Labels: map[string]string{"lazyloader": "true", "lazyloader.hosts": "a.com"}},
+	}}
+	d := NewDiscovery(host)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := d.FindContainerByHostname(context.Background(), "a.com")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, host.listCalls)
+}
+
+func TestWatchEventsCoalescesRefreshes(t *testing.T) {
+	listed, release := make(chan struct{}), make(chan struct{})
+	host := &fakeHost{
+		events: make(chan events.Message),
+		onList: func() {
+			listed <- struct{}{}
+			<-release
+		},
+	}
+	d := NewDiscovery(host)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.WatchEvents(ctx)
+
+	start := events.Message{Action: events.ActionStart, Actor: events.Actor{ID: "a"}}
+	host.events <- start
+	<-listed
+
+	// A burst during the rebuild results in one more rebuild, not one per event
+	for range 5 {
+		host.events <- start
+	}
+	time.Sleep(20 * time.Millisecond) // Let the last event be handled
+	release <- struct{}{}
+	<-listed
+	release <- struct{}{}
+
+	select {
+	case <-listed:
+		t.Fatal("unexpected third rebuild")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
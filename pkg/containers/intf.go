@@ -3,7 +3,9 @@ package containers
 import (
 	"context"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
 )
 
 type Host interface {
@@ -12,7 +14,14 @@ type Host interface {
 	ContainerStart(ctx context.Context, id string, opt container.StartOptions) error
 	ContainerStop(ctx context.Context, id string, opt container.StopOptions) error
 
+	ContainerInspect(ctx context.Context, id string) (container.InspectResponse, error)
 	ContainerStatsOneShot(ctx context.Context, id string) (container.StatsResponseReader, error)
 
+	ContainerExecCreate(ctx context.Context, id string, options container.ExecOptions) (container.ExecCreateResponse, error)
+	ContainerExecAttach(ctx context.Context, execID string, config container.ExecAttachOptions) (types.HijackedResponse, error)
+	ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error)
+
+	Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error)
+
 	Close() error
 }
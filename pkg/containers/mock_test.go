@@ -0,0 +1,74 @@
+package containers
+
+import (
+	"context"
+	"testing"
+	"traefik-lazyload/pkg/config"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+)
+
+// Updates the config for the duration of a test
+func setConfig(t *testing.T, fn func(cfg *config.ConfigModel)) {
+	t.Helper()
+	prev := config.Current()
+	t.Cleanup(func() { config.Apply(prev) })
+	config.Update(fn)
+}
+
+// fakeHost is a minimal in-memory Host that returns a static container list
+type fakeHost struct {
+	containers []container.Summary
+	listCalls  int
+	onList     func()              // Called on each listing, if set
+	events     chan events.Message // Returned by Events, if set
+}
+
+func (s *fakeHost) ContainerList(ctx context.Context, clo container.ListOptions) ([]container.Summary, error) {
+	if s.onList != nil {
+		s.onList()
+	}
+	s.listCalls++
+	return s.containers, nil
+}
+
+func (s *fakeHost) ContainerStart(ctx context.Context, id string, opt container.StartOptions) error {
+	return nil
+}
+
+func (s *fakeHost) ContainerStop(ctx context.Context, id string, opt container.StopOptions) error {
+	return nil
+}
+
+func (s *fakeHost) ContainerInspect(ctx context.Context, id string) (container.InspectResponse, error) {
+	return container.InspectResponse{}, nil
+}
+
+func (s *fakeHost) ContainerStatsOneShot(ctx context.Context, id string) (container.StatsResponseReader, error) {
+	return container.StatsResponseReader{}, nil
+}
+
+func (s *fakeHost) ContainerExecCreate(ctx context.Context, id string, options container.ExecOptions) (container.ExecCreateResponse, error) {
+	return container.ExecCreateResponse{}, nil
+}
+
+func (s *fakeHost) ContainerExecAttach(ctx context.Context, execID string, config container.ExecAttachOptions) (types.HijackedResponse, error) {
+	return types.HijackedResponse{}, nil
+}
+
+func (s *fakeHost) ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error) {
+	return container.ExecInspect{}, nil
+}
+
+func (s *fakeHost) Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error) {
+	if s.events != nil {
+		return s.events, make(chan error)
+	}
+	return make(chan events.Message), make(chan error)
+}
+
+func (s *fakeHost) Close() error {
+	return nil
+}
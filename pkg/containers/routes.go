@@ -0,0 +1,71 @@
+package containers
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Route is a traefik router resolved from somewhere other than container labels
+// (eg. the traefik API or file-provider config)
+type Route struct {
+	Router   string   // Router name, without provider suffix
+	Provider string   // Traefik provider the router came from (eg. docker, file)
+	Rule     string   // Traefik rule
+	Service  string   // Service name, without provider suffix
+	Servers  []string // Backend server URLs of the service
+}
+
+// RouteSource supplies routes that are matched against lazyload containers
+// in addition to their labels
+type RouteSource interface {
+	Routes(ctx context.Context) ([]Route, error)
+}
+
+// resolveRouteContainer finds the container backing a route; first by the service's
+// server URLs, then by docker-provider router/service name
+func resolveRouteContainer(r *Route, cts []Wrapper) *Wrapper {
+	for _, server := range r.Servers {
+		host := serverHostname(server)
+		if host == "" {
+			continue
+		}
+		for i := range cts {
+			if cts[i].HasAddress(host) {
+				return &cts[i]
+			}
+		}
+	}
+
+	if r.Provider == "docker" {
+		for i := range cts {
+			if cts[i].HasTraefikRouter(r.Router) || cts[i].HasTraefikService(r.Service) {
+				return &cts[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// serverHostname extracts the hostname (or IP) from a server url, eg. http://web:80 -> web
+func serverHostname(server string) string {
+	u, err := url.Parse(server)
+	if err != nil || u.Host == "" {
+		// Might be a bare host:port
+		if host, _, err := net.SplitHostPort(server); err == nil {
+			return host
+		}
+		return ""
+	}
+	return u.Hostname()
+}
+
+// TrimProvider strips the @provider suffix from a traefik name
+func TrimProvider(name string) string {
+	if idx := strings.LastIndexByte(name, '@'); idx >= 0 {
+		return name[:idx]
+	}
+	return name
+}
@@ -0,0 +1,52 @@
+package containers
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveRouteContainer(t *testing.T) {
+	cts := wrapContainers(
+		container.Summary{ID: "aaaaaaaaaaaa", Names: []string{"/wiki"}},
+		container.Summary{ID: "bbbbbbbbbbbb", Names: []string{"/web"}, NetworkSettings: &container.NetworkSettingsSummary{
+			Networks: map[string]*network.EndpointSettings{
+				"proxy": {IPAddress: "172.18.0.5", Aliases: []string{"frontend"}},
+			},
+		}},
+		container.Summary{ID: "cccccccccccc", Names: []string{"/app-1"}, Labels: map[string]string{
+			"com.docker.compose.service":                       "app",
+			"com.docker.compose.project":                       "stack",
+			"traefik.http.routers.app.rule":                    "Host(`app.com`)",
+			"traefik.http.services.appsvc.loadbalancer.server": "80",
+		}},
+	)
+
+	tests := []struct {
+		name     string
+		route    Route
+		expected string
+	}{
+		{"server by name", Route{Servers: []string{"http://wiki:3000"}}, "aaaaaaaaaaaa"},
+		{"server by ip", Route{Servers: []string{"http://172.18.0.5:80"}}, "bbbbbbbbbbbb"},
+		{"server by alias", Route{Servers: []string{"frontend:80"}}, "bbbbbbbbbbbb"},
+		{"docker router", Route{Provider: "docker", Router: "app"}, "cccccccccccc"},
+		{"docker service label", Route{Provider: "docker", Service: "appsvc"}, "cccccccccccc"},
+		{"docker compose service", Route{Provider: "docker", Service: "app-stack"}, "cccccccccccc"},
+		{"file provider by name only", Route{Provider: "file", Router: "app"}, ""},
+		{"unknown server", Route{Servers: []string{"http://10.0.0.1"}}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ct := resolveRouteContainer(&tt.route, cts)
+			if tt.expected == "" {
+				assert.Nil(t, ct)
+			} else if assert.NotNil(t, ct) {
+				assert.Equal(t, tt.expected, ct.ID)
+			}
+		})
+	}
+}
@@ -1,5 +1,13 @@
 package containers
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
 func strSliceContains(slice []string, s string) bool {
 	for _, item := range slice {
 		if item == s {
@@ -8,3 +16,59 @@ func strSliceContains(slice []string, s string) bool {
 	}
 	return false
 }
+
+var byteUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"k":   1e3,
+	"kb":  1e3,
+	"kib": 1 << 10,
+	"m":   1e6,
+	"mb":  1e6,
+	"mib": 1 << 20,
+	"g":   1e9,
+	"gb":  1e9,
+	"gib": 1 << 30,
+}
+
+var ratePeriods = map[string]time.Duration{
+	"s":    time.Second,
+	"sec":  time.Second,
+	"m":    time.Minute,
+	"min":  time.Minute,
+	"h":    time.Hour,
+	"hour": time.Hour,
+}
+
+// ParseByteRate parses a byte rate like 10KiB/min into bytes per second. The period is
+// optional and defaults to per-second
+func ParseByteRate(val string) (float64, error) {
+	size, period, hasPeriod := strings.Cut(strings.TrimSpace(val), "/")
+
+	perDuration := time.Second
+	if hasPeriod {
+		var ok bool
+		if perDuration, ok = ratePeriods[strings.ToLower(strings.TrimSpace(period))]; !ok {
+			return 0, fmt.Errorf("unknown rate period %q", period)
+		}
+	}
+
+	size = strings.TrimSpace(size)
+	numEnd := strings.IndexFunc(size, func(r rune) bool {
+		return !unicode.IsDigit(r) && r != '.'
+	})
+	if numEnd < 0 {
+		numEnd = len(size)
+	}
+
+	num, err := strconv.ParseFloat(size[:numEnd], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	unit, ok := byteUnits[strings.ToLower(strings.TrimSpace(size[numEnd:]))]
+	if !ok {
+		return 0, fmt.Errorf("unknown size unit %q", size[numEnd:])
+	}
+
+	return num * unit / perDuration.Seconds(), nil
+}
@@ -10,3 +10,26 @@ func TestSliceContainers(t *testing.T) {
 	assert.True(t, strSliceContains([]string{"hello", "thar"}, "thar"))
 	assert.False(t, strSliceContains([]string{"hello", "thar"}, "th"))
 }
+
+func TestParseByteRate(t *testing.T) {
+	tests := []struct {
+		val      string
+		expected float64
+	}{
+		{"100", 100},
+		{"100B/s", 100},
+		{"10KiB/min", 10 * 1024 / 60.0},
+		{"1.5 MB / h", 1.5e6 / 3600},
+		{"2k/sec", 2000},
+	}
+	for _, tt := range tests {
+		rate, err := ParseByteRate(tt.val)
+		assert.NoError(t, err, tt.val)
+		assert.InDelta(t, tt.expected, rate, 0.001, tt.val)
+	}
+
+	for _, val := range []string{"", "KiB", "10XB/s", "10KiB/day", "abc/min"} {
+		_, err := ParseByteRate(val)
+		assert.Error(t, err, val)
+	}
+}
@@ -2,6 +2,7 @@ package containers
 
 import (
 	"fmt"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -17,15 +18,17 @@ type Wrapper struct {
 	container.Summary
 }
 
-// Human-consumable name + ID
-func (s *Wrapper) NameID() string {
-	var name string
+// Human-consumable name
+func (s *Wrapper) Name() string {
 	if len(s.Names) > 0 {
-		name = strings.TrimPrefix(s.Names[0], "/")
-	} else {
-		name = s.Image
+		return strings.TrimPrefix(s.Names[0], "/")
 	}
-	return fmt.Sprintf("%s (%s)", name, s.ShortId())
+	return s.Image
+}
+
+// Human-consumable name + ID
+func (s *Wrapper) NameID() string {
+	return fmt.Sprintf("%s (%s)", s.Name(), s.ShortId())
 }
 
 // char-len capped ID
@@ -39,7 +42,7 @@ func (s *Wrapper) ShortId() string {
 
 // Returns config labels with the prefix trimmed
 func (s *Wrapper) ConfigLabels() map[string]string {
-	var matchString = config.Model.LabelPrefix + "."
+	var matchString = config.Current().LabelPrefix + "."
 
 	ret := make(map[string]string)
 	for k, v := range s.Labels {
@@ -51,6 +54,25 @@ func (s *Wrapper) ConfigLabels() map[string]string {
 	return ret
 }
 
+// Config labels to show (eg. on the status page), with secrets masked
+func (s *Wrapper) StatusLabels() map[string]string {
+	ret := s.ConfigLabels()
+	for sublabel := range ret {
+		if IsSecretSublabel(sublabel) {
+			ret[sublabel] = "(set)"
+		}
+	}
+	return ret
+}
+
+// Sublabels holding secrets, which are never shown or persisted
+var secretSublabels = []string{"heartbeattoken"}
+
+// IsSecretSublabel reports whether a sublabel (without the prefix) holds a secret
+func IsSecretSublabel(sublabel string) bool {
+	return slices.Contains(secretSublabels, sublabel)
+}
+
 func (s *Wrapper) Config(sublabel string) (string, bool) {
 	ret, ok := s.Labels[config.SubLabel(sublabel)]
 	return ret, ok
@@ -98,6 +120,112 @@ func (s *Wrapper) ConfigDuration(sublabel string, dflt time.Duration) (time.Dura
 	}
 }
 
+// Parses a percentage, with or without the % sign (eg. 5% or 5)
+func (s *Wrapper) ConfigPercent(sublabel string, dflt float64) (float64, bool) {
+	val, ok := s.Config(sublabel)
+	if !ok {
+		return dflt, false
+	}
+
+	if pct, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(val, "%")), 64); err != nil {
+		logrus.Warnf("Unable to parse %s on %s: %v. Using default of %g%%", sublabel, s.NameID(), err, dflt)
+		return dflt, false
+	} else {
+		return pct, true
+	}
+}
+
+// Parses a byte rate (eg. 10KiB/min), returned as bytes per second
+func (s *Wrapper) ConfigByteRate(sublabel string, dflt float64) (float64, bool) {
+	val, ok := s.Config(sublabel)
+	if !ok {
+		return dflt, false
+	}
+
+	if rate, err := ParseByteRate(val); err != nil {
+		logrus.Warnf("Unable to parse %s on %s: %v. Using default of %gB/s", sublabel, s.NameID(), err, dflt)
+		return dflt, false
+	} else {
+		return rate, true
+	}
+}
+
+// true if host is one of the container's names, network aliases or IPs
+func (s *Wrapper) HasAddress(host string) bool {
+	for _, name := range s.Names {
+		if strings.TrimPrefix(name, "/") == host {
+			return true
+		}
+	}
+	if s.ID == host || s.ShortId() == host {
+		return true
+	}
+	if s.NetworkSettings == nil {
+		return false
+	}
+	for _, ep := range s.NetworkSettings.Networks {
+		if ep == nil {
+			continue
+		}
+		if ep.IPAddress == host || ep.GlobalIPv6Address == host ||
+			strSliceContains(ep.Aliases, host) || strSliceContains(ep.DNSNames, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// true if the container defines a traefik router by this name via labels
+func (s *Wrapper) HasTraefikRouter(name string) bool {
+	_, ok := s.Labels["traefik.http.routers."+name+".rule"]
+	return ok
+}
+
+// true if the container backs a traefik service by this name, either via labels
+// or the docker provider's default service name
+func (s *Wrapper) HasTraefikService(name string) bool {
+	prefix := "traefik.http.services." + name + "."
+	for k := range s.Labels {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+
+	// Default name assigned by traefik's docker provider
+	if svc, ok := s.Labels["com.docker.compose.service"]; ok {
+		return svc+"-"+s.Labels["com.docker.compose.project"] == name
+	}
+	for _, n := range s.Names {
+		if strings.TrimPrefix(n, "/") == name {
+			return true
+		}
+	}
+	return false
+}
+
+// The port traefik forwards to, from the service labels or else the first private port
+func (s *Wrapper) ServicePort() (int, bool) {
+	keys := make([]string, 0)
+	for k := range s.Labels {
+		if strings.HasPrefix(k, "traefik.http.services.") && strings.HasSuffix(k, ".loadbalancer.server.port") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if port, err := strconv.Atoi(s.Labels[k]); err == nil {
+			return port, true
+		}
+	}
+
+	for _, p := range s.Ports {
+		if p.Type == "" || p.Type == "tcp" {
+			return int(p.PrivatePort), true
+		}
+	}
+	return 0, false
+}
+
 // true if state is running
 func (s *Wrapper) IsRunning() bool {
 	return s.State == container.StateRunning
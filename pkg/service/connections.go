@@ -0,0 +1,98 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+)
+
+// TCP states, as in /proc/net/tcp
+const (
+	tcpEstablished = "01"
+	tcpListen      = "0A"
+)
+
+// Count established connections to listening ports (ie. inbound connections) in the container's
+// network namespace. Reads /proc/<pid>/net/tcp{,6} via the host's procfs if configured, otherwise
+// execs in the container
+func (s *Core) establishedConnections(ctx context.Context, cid string) (int, error) {
+	if config.Current().ProcRoot != "" {
+		inspect, err := s.client.ContainerInspect(ctx, cid)
+		if err != nil {
+			return 0, err
+		}
+		if inspect.State == nil || inspect.State.Pid == 0 {
+			return 0, errors.New("container not running")
+		}
+		if tables, err := readProcNetTCP(filepath.Join(config.Current().ProcRoot, strconv.Itoa(inspect.State.Pid), "net")); err == nil {
+			return countEstablished(tables...), nil
+		}
+	}
+
+	// tcp6 may not exist, so cat's exit code is ignored as long as there's output
+	stdout, stderr, _, err := containers.Exec(ctx, s.client, cid, []string{"cat", "/proc/net/tcp", "/proc/net/tcp6"})
+	if err != nil {
+		return 0, err
+	}
+	if stdout == "" {
+		return 0, errors.New("unable to read /proc/net/tcp in container: " + strings.TrimSpace(stderr))
+	}
+	return countEstablished(stdout), nil
+}
+
+func readProcNetTCP(netDir string) ([]string, error) {
+	var tables []string
+	for _, name := range []string{"tcp", "tcp6"} {
+		data, err := os.ReadFile(filepath.Join(netDir, name)) //nolint:gosec
+		if err != nil {
+			if name == "tcp6" && errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		tables = append(tables, string(data))
+	}
+	return tables, nil
+}
+
+// countEstablished counts established connections whose local port is a listening port,
+// given the contents of /proc/net/tcp{,6}
+func countEstablished(tables ...string) int {
+	var established []string // local ports
+	listening := make(map[string]bool)
+
+	for _, table := range tables {
+		scanner := bufio.NewScanner(strings.NewReader(table))
+		for scanner.Scan() {
+			// sl local_address rem_address st ...
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 4 || fields[0] == "sl" {
+				continue
+			}
+			_, port, ok := strings.Cut(fields[1], ":")
+			if !ok {
+				continue
+			}
+			switch fields[3] {
+			case tcpListen:
+				listening[port] = true
+			case tcpEstablished:
+				established = append(established, port)
+			}
+		}
+	}
+
+	count := 0
+	for _, port := range established {
+		if listening[port] {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testProcNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1 1 0000000000000000 100 0 0 10 0
+   1: 0B00007F:A1B2 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 2 1 0000000000000000 100 0 0 10 0
+   2: 050012AC:1F90 010012AC:D431 01 00000000:00000000 00:00000000 00000000     0        0 3 1 0000000000000000 20 4 30 10 -1
+   3: 050012AC:1F90 010012AC:D432 01 00000000:00000000 00:00000000 00000000     0        0 4 1 0000000000000000 20 4 30 10 -1
+   4: 050012AC:1F90 010012AC:D433 06 00000000:00000000 00:00000000 00000000     0        0 0 3 0000000000000000
+   5: 050012AC:C350 060012AC:0CEA 01 00000000:00000000 00:00000000 00000000     0        0 5 1 0000000000000000 20 4 30 10 -1
+`
+
+const testProcNetTCP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:0016 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 6 1 0000000000000000 100 0 0 10 0
+   1: 0000000000000000FFFF0000050012AC:0016 0000000000000000FFFF0000010012AC:E001 01 00000000:00000000 00:00000000 00000000     0        0 7 1 0000000000000000 20 4 30 10 -1
+`
+
+func TestCountEstablished(t *testing.T) {
+	// 2 inbound on :8080 (time-wait and the outbound connection to :3306 don't count)
+	assert.Equal(t, 2, countEstablished(testProcNetTCP))
+	// +1 inbound on :22 via ipv6
+	assert.Equal(t, 3, countEstablished(testProcNetTCP, testProcNetTCP6))
+	assert.Equal(t, 0, countEstablished(""))
+}
+
+func TestReadProcNetTCP(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "tcp"), []byte(testProcNetTCP), 0o600))
+
+	// tcp6 is optional
+	tables, err := readProcNetTCP(dir)
+	assert.NoError(t, err)
+	assert.Len(t, tables, 1)
+
+	_, err = readProcNetTCP(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
@@ -1,72 +1,286 @@
 package service
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 	"traefik-lazyload/pkg/config"
 	"traefik-lazyload/pkg/containers"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/sirupsen/logrus"
+)
+
+// Which activity signals keep a container from going idle
+const (
+	activityNetwork = "network" // Network traffic only
+	activityCPU     = "cpu"     // CPU usage only
+	activityAny     = "any"     // Either signal
+	activityAll     = "all"     // Both signals at once
+
+	// Only requests (eg. from traefik's access log) count, which otherwise always do
+	activityRequests = "requests"
 )
 
 type containerSettings struct {
-	stopDelay     time.Duration
+	stopDelay     time.Duration // From the label; see currentStopDelay
+	hasStopDelay  bool
 	waitForCode   int
 	waitForPath   string
 	waitForMethod string
 	needs         []string
+	idleCPU       float64  // CPU % at or below which the container is considered idle
+	idleBytes     float64  // Network bytes/sec at or below which the container is considered idle
+	idleNetworks  []string // Networks (or interfaces) whose traffic counts as activity (empty is all)
+	idleConns     int      // Inbound connection count at or below which the container is idle (-1 is disabled)
+	activity      string   // How activity signals combine
+	stopOptions   container.StopOptions
+	hooks         hookSettings
+
+	heartbeatToken string // Token the container can use to report activity itself
+	idleCheckPath  string // Path on the container asked whether it's busy before stopping
+	idleCheckPort  int
 }
 
+// ContainerState is a managed container. Settings are fixed; everything else is guarded by mux
 type ContainerState struct {
-	name string
+	name      string
+	container string            // Name without the id, stable across re-creation
+	labels    map[string]string // For usage accounting
 	containerSettings
-	lastRecv, lastSend int64 // Last network traffic, used to see if idle
+
+	mux                sync.Mutex
+	state              State
+	stateSince         time.Time
+	lastRecv, lastSend int64     // Last network traffic, used to see if idle
+	lastNetSample      time.Time // When lastRecv/lastSend were sampled
+	idleInterfaces     []string  // Interfaces resolved from idleNetworks (nil is all)
+	interfacesResolved bool
+	unmappedNetworks   []string // idleNetworks that couldn't be mapped to interfaces, so all traffic is activity
+	netRate            float64  // Network bytes/sec between the last two polls
+	lastCPUTotal       uint64   // Last container CPU usage (ns), used for the usage between polls
+	lastCPUSystem      uint64   // Last host CPU usage (ns)
+	cpuPercent         float64  // CPU usage between the last two polls
 	lastActivity       time.Time
 	started            time.Time
-	pinned             bool // Don't remove, even if not started
 }
 
 func newStateFromContainer(ct *containers.Wrapper) *ContainerState {
 	return &ContainerState{
 		name:              ct.NameID(),
+		container:         ct.Name(),
+		labels:            usageLabels(ct.Labels),
 		containerSettings: extractContainerLabels(ct),
+		state:             StateStopped,
+		stateSince:        time.Now(),
 		lastActivity:      time.Now(),
 		started:           time.Now(),
 	}
 }
 
 func extractContainerLabels(ct *containers.Wrapper) (target containerSettings) {
-	target.stopDelay, _ = ct.ConfigDuration("stopdelay", config.Model.StopDelay)
+	target.stopDelay, target.hasStopDelay = ct.ConfigDuration("stopdelay", config.Current().StopDelay)
 	target.waitForCode, _ = ct.ConfigInt("waitforcode", 200)
 	target.waitForPath, _ = ct.ConfigOrDefault("waitforpath", "/")
 	target.waitForMethod, _ = ct.ConfigOrDefault("waitformethod", "HEAD")
 	target.needs, _ = ct.ConfigCSV("needs", nil)
+	target.stopOptions = stopOptionsFor(ct, "")
+	target.hooks = extractHooks(ct)
+
+	target.idleBytes, _ = ct.ConfigByteRate("idlebytes", 0)
+	target.idleNetworks, _ = ct.ConfigCSV("idlenetworks", nil)
+	target.idleConns, _ = ct.ConfigInt("idleconnections", -1)
+	target.heartbeatToken, _ = ct.ConfigOrDefault("heartbeattoken", "")
+	target.idleCheckPort, target.idleCheckPath = parseIdleCheck(ct)
+
+	var hasIdleCPU bool
+	target.idleCPU, hasIdleCPU = ct.ConfigPercent("idlecpu", 0)
+
+	// If a cpu threshold is given, assume it should count towards activity
+	dfltActivity := activityNetwork
+	if hasIdleCPU {
+		dfltActivity = activityAny
+	}
+	target.activity, _ = ct.ConfigOrDefault("activity", dfltActivity)
+	switch target.activity {
+	case activityNetwork, activityCPU, activityAny, activityAll, activityRequests:
+	default:
+		logrus.Warnf("Unknown activity %q on %s. Using default of %s", target.activity, ct.NameID(), dfltActivity)
+		target.activity = dfltActivity
+	}
 	return
 }
 
+// Stop timeout and signal from the stoptimeout and stopsignal labels, with a prefix (eg. provides.
+// for dependencies). Unset, docker's defaults (or the container's own) apply
+func stopOptionsFor(ct *containers.Wrapper, prefix string) (opts container.StopOptions) {
+	if timeout, ok := ct.ConfigDuration(prefix+"stoptimeout", 0); ok {
+		seconds := int(timeout.Round(time.Second).Seconds()) // -1 waits forever
+		opts.Timeout = &seconds
+	}
+	opts.Signal, _ = ct.ConfigOrDefault(prefix+"stopsignal", "")
+	return opts
+}
+
+// Parse the idlecheck label, a path with an optional port (eg. /idle or :8080/idle). Without a
+// port, the port traefik forwards to is used
+func parseIdleCheck(ct *containers.Wrapper) (port int, path string) {
+	val, ok := ct.Config("idlecheck")
+	if !ok || val == "" {
+		return 0, ""
+	}
+	return parsePortPath(ct, "idlecheck", val)
+}
+
+// Parse a path on the container with an optional port, as in the idlecheck label
+func parsePortPath(ct *containers.Wrapper, label, val string) (port int, path string) {
+	path = val
+	if strings.HasPrefix(val, ":") {
+		portStr, rest, _ := strings.Cut(val[1:], "/")
+		if p, err := strconv.Atoi(portStr); err == nil {
+			port, path = p, "/"+rest
+		} else {
+			logrus.Warnf("Unable to parse %s port on %s: %v", label, ct.NameID(), err)
+			return 0, ""
+		}
+	}
+	if port == 0 {
+		var ok bool
+		if port, ok = ct.ServicePort(); !ok {
+			port = 80
+		}
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return port, path
+}
+
 func (s *ContainerState) Name() string {
 	return s.name
 }
 
 func (s *ContainerState) LastActive() time.Time {
+	s.mux.Lock()
+	defer s.mux.Unlock()
 	return s.lastActivity
 }
 
 func (s *ContainerState) LastActiveAge() string { // FIXME: Return duration (update UI)
+	s.mux.Lock()
+	defer s.mux.Unlock()
 	return time.Since(s.lastActivity).Round(time.Second).String()
 }
 
 func (s *ContainerState) Rx() int64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
 	return s.lastRecv
 }
 
 func (s *ContainerState) Tx() int64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
 	return s.lastSend
 }
 
+// Network rate between the last two polls
+func (s *ContainerState) NetRate() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return formatByteRate(s.netRate)
+}
+
+// Records a network traffic sample, returning whether the traffic since the previous
+// sample is above the idle threshold. Called with mux held
+func (s *ContainerState) sampleNetwork(rx, tx int64, now time.Time) bool {
+	reset := rx < s.lastRecv || tx < s.lastSend
+	delta := (rx - s.lastRecv) + (tx - s.lastSend)
+	elapsed := now.Sub(s.lastNetSample)
+	first := s.lastNetSample.IsZero()
+
+	s.lastRecv, s.lastSend, s.lastNetSample = rx, tx, now
+
+	switch {
+	case reset: // Counters went backwards, eg. the container restarted
+		s.netRate = 0
+		return true
+	case first || elapsed <= 0:
+		return delta > 0
+	}
+
+	s.netRate = float64(delta) / elapsed.Seconds()
+	return delta > 0 && s.netRate > s.idleBytes
+}
+
+// Records a stats sample, moving between Running and Idle. Returns true if the container has been
+// idle for longer than its stop delay
+func (s *ContainerState) sampleActivity(stats *container.StatsResponse, interfaces []string, now time.Time) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if !s.state.Ready() {
+		return false
+	}
+
+	rx, tx := sumNetworkBytes(stats.Networks, interfaces)
+	netActive := s.sampleNetwork(rx, tx, now)
+	if len(s.unmappedNetworks) > 0 {
+		// Traffic on the idle networks can't be told apart from the rest, so it never looks idle
+		netActive = true
+	}
+
+	// One-shot stats don't include the previous sample, so diff against our own
+	prevTotal, prevSystem := s.lastCPUTotal, s.lastCPUSystem
+	if stats.PreCPUStats.SystemUsage > 0 {
+		prevTotal, prevSystem = stats.PreCPUStats.CPUUsage.TotalUsage, stats.PreCPUStats.SystemUsage
+	}
+	if prevSystem > 0 {
+		s.cpuPercent = cpuPercent(&stats.CPUStats, prevTotal, prevSystem)
+	}
+	s.lastCPUTotal = stats.CPUStats.CPUUsage.TotalUsage
+	s.lastCPUSystem = stats.CPUStats.SystemUsage
+	cpuActive := s.cpuPercent > s.idleCPU
+
+	if isActive(s.activity, netActive, cpuActive) {
+		s.lastActivity = now
+		if s.state == StateIdle {
+			s.transitionLocked(StateRunning)
+		}
+		return false
+	}
+
+	if s.state == StateRunning {
+		s.transitionLocked(StateIdle)
+	}
+	return now.After(s.lastActivity.Add(s.currentStopDelay()))
+}
+
+// CPU usage as a percentage of one core, between the last two polls
+func (s *ContainerState) CPU() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return fmt.Sprintf("%.1f%%", s.cpuPercent)
+}
+
 func (s *ContainerState) Started() time.Time {
+	s.mux.Lock()
+	defer s.mux.Unlock()
 	return s.started
 }
 
 func (s *containerSettings) StopDelay() string { // FIXME: Return duration (update UI)
-	return s.stopDelay.String()
+	return s.currentStopDelay().String()
+}
+
+// The stop delay from the label, or else the configured default (which can be reloaded)
+func (s *containerSettings) currentStopDelay() time.Duration {
+	if s.hasStopDelay {
+		return s.stopDelay
+	}
+	return config.Current().StopDelay
 }
 
 func (s *ContainerState) WaitForCode() int {
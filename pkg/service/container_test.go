@@ -0,0 +1,79 @@
+package service
+
+import (
+	"testing"
+	"time"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleNetwork(t *testing.T) {
+	now := time.Now()
+	ct := &ContainerState{containerSettings: containerSettings{idleBytes: 100}}
+
+	// First sample counts any traffic
+	assert.True(t, ct.sampleNetwork(1000, 0, now))
+
+	// 50 B/s is below the threshold
+	now = now.Add(10 * time.Second)
+	assert.False(t, ct.sampleNetwork(1300, 200, now))
+	assert.InDelta(t, 50.0, ct.netRate, 0.001)
+
+	// 200 B/s is above
+	now = now.Add(10 * time.Second)
+	assert.True(t, ct.sampleNetwork(3300, 200, now))
+	assert.InDelta(t, 200.0, ct.netRate, 0.001)
+
+	// No traffic
+	now = now.Add(10 * time.Second)
+	assert.False(t, ct.sampleNetwork(3300, 200, now))
+
+	// Counter reset
+	now = now.Add(10 * time.Second)
+	assert.True(t, ct.sampleNetwork(10, 10, now))
+}
+
+func TestSampleActivityUnmappedNetworks(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.StopDelay = time.Minute })
+	now := time.Now()
+	stats := &container.StatsResponse{}
+
+	for _, unmapped := range [][]string{nil, {"backend"}} {
+		ct := &ContainerState{state: StateRunning, lastActivity: now, unmappedNetworks: unmapped,
+			containerSettings: containerSettings{activity: activityNetwork}}
+		ct.sampleActivity(stats, nil, now)
+
+		shouldStop := ct.sampleActivity(stats, nil, now.Add(time.Hour))
+		assert.Equal(t, unmapped == nil, shouldStop, "unmapped %v", unmapped)
+	}
+}
+
+func TestSampleNetworkNoThreshold(t *testing.T) {
+	now := time.Now()
+	ct := &ContainerState{}
+
+	assert.True(t, ct.sampleNetwork(1, 1, now))
+	assert.True(t, ct.sampleNetwork(2, 1, now.Add(time.Minute)))
+	assert.False(t, ct.sampleNetwork(2, 1, now.Add(2*time.Minute)))
+}
+
+func TestStopOptionsFor(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.LabelPrefix = "lazyloader" })
+
+	ct := &containers.Wrapper{Summary: container.Summary{Labels: map[string]string{
+		"lazyloader.stoptimeout":          "1m",
+		"lazyloader.provides.stopsignal":  "SIGINT",
+		"lazyloader.provides.stoptimeout": "bad",
+	}}}
+
+	opts := stopOptionsFor(ct, "")
+	assert.Equal(t, 60, *opts.Timeout)
+	assert.Equal(t, "", opts.Signal)
+
+	opts = stopOptionsFor(ct, "provides.")
+	assert.Nil(t, opts.Timeout) // Docker's default
+	assert.Equal(t, "SIGINT", opts.Signal)
+}
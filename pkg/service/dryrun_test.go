@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRunStop(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.DryRun = true })
+
+	events, _ := NewEventLog(10, "")
+	usage, _ := NewUsageTracker("")
+	ct := &ContainerState{name: "app", state: StateStopping, lastActivity: time.Now().Add(-time.Hour)}
+	core := &Core{events: events, usage: usage, active: map[string]*ContainerState{"a": ct}}
+
+	// Never reaches the (nil) docker client
+	assert.False(t, core.stopContainerAndDependencies(context.Background(), "a", ct))
+	assert.Equal(t, StateRunning, ct.State(), "still running, and counts as active again")
+	assert.True(t, time.Since(ct.LastActive()) < time.Minute)
+	assert.Contains(t, core.snapshot(), "a")
+
+	actions := core.DryRunActions(10)
+	assert.Len(t, actions, 1)
+	assert.Equal(t, EventWouldStop, actions[0].Type)
+	assert.Equal(t, "1h0m0s", actions[0].Duration)
+}
+
+func TestDryRunOff(t *testing.T) {
+	events, _ := NewEventLog(10, "")
+	core := &Core{events: events}
+
+	assert.NoError(t, core.dryRun(Event{Type: EventWouldStart, Container: "app"}))
+	assert.Empty(t, core.DryRunActions(10))
+}
+
+func TestDryRunStartReportedOnce(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) {
+		cfg.LabelPrefix = "lazyloader"
+		cfg.DryRun = true
+		cfg.Timeout = time.Second
+		cfg.StopDelay = time.Minute
+	})
+
+	docker := &fakeDocker{containers: []container.Summary{{ID: "a", Names: []string{"/app"}, State: "exited", Labels: map[string]string{
+		"lazyloader": "true", "lazyloader.hosts": "app.com",
+	}}}}
+	events, _ := NewEventLog(10, "")
+	usage, _ := NewUsageTracker("")
+	core := &Core{
+		client: docker, discovery: containers.NewDiscovery(docker), events: events, usage: usage,
+		active: make(map[string]*ContainerState), wouldStart: make(map[string]time.Time), startCtx: context.Background(),
+	}
+
+	for range 3 {
+		_, err := core.StartHost("app.com", Requester{})
+		assert.NoError(t, err)
+		core.starting.Wait()
+	}
+	assert.Len(t, core.Events(EventFilter{Type: EventStartRequested}), 1)
+	assert.Len(t, core.DryRunActions(10), 1)
+	assert.Empty(t, core.snapshot())
+
+	// Reported again once the stop delay has passed
+	core.wouldStart["a"] = time.Now().Add(-time.Hour)
+	core.StartHost("app.com", Requester{})
+	core.starting.Wait()
+	assert.Len(t, core.DryRunActions(10), 2)
+}
+
+func TestDryRunStopAll(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.DryRun = true })
+
+	states := map[string]State{"a": StateRunning, "b": StateIdle, "c": StateWaitingReady, "d": StateFailed}
+	events, _ := NewEventLog(10, "")
+	core := &Core{events: events, active: make(map[string]*ContainerState)}
+	for cid, state := range states {
+		core.active[cid] = &ContainerState{name: cid, state: state}
+	}
+
+	// Never reaches the (nil) docker client
+	core.StopAll(context.Background())
+	for cid, state := range states {
+		assert.Equal(t, state, core.active[cid].State(), cid)
+	}
+	assert.Len(t, core.DryRunActions(10), len(states))
+}
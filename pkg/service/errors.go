@@ -4,4 +4,8 @@ import "errors"
 
 var (
 	ErrProviderNotFound = errors.New("provider not found")
+	ErrShuttingDown     = errors.New("shutting down")
+	ErrNotRunning       = errors.New("not running")
+
+	errDryRun = errors.New("skipped in dry-run mode")
 )
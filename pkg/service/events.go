@@ -0,0 +1,149 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EventType is a kind of lifecycle event
+type EventType string
+
+const (
+	EventStartRequested    EventType = "start-requested"
+	EventDependencyStarted EventType = "dependency-started"
+	EventReady             EventType = "ready"
+	EventIdleStopped       EventType = "idle-stopped"
+	EventEvicted           EventType = "evicted" // Stopped by the lazyloader, but not for being idle
+	EventExternallyStopped EventType = "externally-stopped"
+	EventStartFailed       EventType = "start-failed"
+	EventHookFailed        EventType = "hook-failed" // A prestop hook; other hooks fail the start
+	EventWouldStart        EventType = "would-start" // Skipped in dry-run mode
+	EventWouldStop         EventType = "would-stop"  // Skipped in dry-run mode
+)
+
+// Requester describes who caused a container to start
+type Requester struct {
+	ClientIP     string `json:"clientIP,omitempty"`
+	UserAgent    string `json:"userAgent,omitempty"`
+	ForwardedFor string `json:"forwardedFor,omitempty"`
+}
+
+// Event is a single entry in the lifecycle history
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      EventType `json:"type"`
+	Container string    `json:"container"`
+	Host      string    `json:"host,omitempty"`
+	Requester
+	Dependency string `json:"dependency,omitempty"` // Dependency that was started
+	Duration   string `json:"duration,omitempty"`   // How long it was idle, or took to become ready
+	Error      string `json:"error,omitempty"`
+}
+
+// EventFilter selects events; empty fields match everything
+type EventFilter struct {
+	Container string    // Substring of the container name
+	Type      EventType // Exact type
+	Limit     int       // Maximum events to return (0 is all)
+}
+
+func (s *EventFilter) matches(e *Event) bool {
+	if s.Container != "" && !strings.Contains(strings.ToLower(e.Container), strings.ToLower(s.Container)) {
+		return false
+	}
+	return s.Type == "" || s.Type == e.Type
+}
+
+// EventLog keeps the most recent lifecycle events in memory, and optionally appends all of them
+// to a JSONL file
+type EventLog struct {
+	mux    sync.Mutex
+	events []Event // ring buffer
+	next   int     // where the next event goes
+	full   bool    // whether the buffer has wrapped
+
+	file *os.File
+}
+
+// NewEventLog keeps `size` events in memory, and appends to the file at path if not empty
+func NewEventLog(size int, path string) (*EventLog, error) {
+	ret := &EventLog{
+		events: make([]Event, max(size, 1)),
+	}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644) //nolint:gosec
+		if err != nil {
+			return nil, err
+		}
+		ret.file = f
+	}
+	return ret, nil
+}
+
+// Record adds an event, stamping its time if not set
+func (s *EventLog) Record(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.events[s.next] = e
+	s.next = (s.next + 1) % len(s.events)
+	if s.next == 0 {
+		s.full = true
+	}
+
+	if s.file != nil {
+		line, _ := json.Marshal(e)
+		if _, err := s.file.Write(append(line, '\n')); err != nil {
+			logrus.Warnf("Unable to write event log: %v", err)
+		}
+	}
+}
+
+// Recent returns the events matching the filter, newest first
+func (s *EventLog) Recent(filter EventFilter) []Event {
+	return s.recent(filter.Limit, filter.matches)
+}
+
+func (s *EventLog) recent(limit int, match func(e *Event) bool) []Event {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	count := s.next
+	if s.full {
+		count = len(s.events)
+	}
+
+	ret := make([]Event, 0)
+	for i := 1; i <= count; i++ {
+		e := &s.events[(s.next-i+len(s.events))%len(s.events)]
+		if !match(e) {
+			continue
+		}
+		ret = append(ret, *e)
+		if limit > 0 && len(ret) >= limit {
+			break
+		}
+	}
+	return ret
+}
+
+func (s *EventLog) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
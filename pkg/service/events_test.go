@@ -0,0 +1,54 @@
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventLogRing(t *testing.T) {
+	log, err := NewEventLog(3, "")
+	assert.NoError(t, err)
+	assert.Empty(t, log.Recent(EventFilter{}))
+
+	log.Record(Event{Type: EventStartRequested, Container: "wiki"})
+	log.Record(Event{Type: EventReady, Container: "wiki"})
+	log.Record(Event{Type: EventStartRequested, Container: "blog"})
+	log.Record(Event{Type: EventIdleStopped, Container: "wiki"})
+
+	events := log.Recent(EventFilter{})
+	assert.Len(t, events, 3, "oldest dropped")
+	assert.Equal(t, EventIdleStopped, events[0].Type, "newest first")
+	assert.Equal(t, EventReady, events[2].Type)
+	assert.False(t, events[0].Time.IsZero())
+
+	assert.Len(t, log.Recent(EventFilter{Container: "WIKI"}), 2)
+	assert.Len(t, log.Recent(EventFilter{Type: EventStartRequested}), 1)
+	assert.Len(t, log.Recent(EventFilter{Limit: 1}), 1)
+	assert.Empty(t, log.Recent(EventFilter{Container: "wiki", Type: EventStartFailed}))
+}
+
+func TestEventLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	log, err := NewEventLog(10, path)
+	assert.NoError(t, err)
+
+	log.Record(Event{Type: EventStartRequested, Container: "wiki", Host: "wiki.example.com", Requester: Requester{ClientIP: "10.0.0.1"}})
+	log.Record(Event{Type: EventStartFailed, Container: "wiki", Error: "no such image"})
+	assert.NoError(t, log.Close())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 2)
+
+	var first Event
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	assert.Equal(t, "wiki.example.com", first.Host)
+	assert.Equal(t, "10.0.0.1", first.ClientIP)
+	assert.Contains(t, lines[1], `"error":"no such image"`)
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+)
+
+// Explanation is how a hostname resolves to a container, and the settings it would be managed with
+type Explanation struct {
+	*containers.RouteExplanation
+	Settings []Setting `json:"settings,omitempty"`
+}
+
+// Setting is an effective setting of a container, with where it came from
+type Setting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"` // label, default, or invalid label (so the default)
+}
+
+// Explain reports how a hostname (or URL) is routed, and the effective settings of the container
+// it resolves to
+func Explain(ctx context.Context, discovery *containers.Discovery, hostOrURL string) (*Explanation, error) {
+	route, err := discovery.Explain(ctx, containers.HostnameOf(hostOrURL))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &Explanation{RouteExplanation: route}
+	if route.Winner != nil {
+		ret.Settings = effectiveSettings(route.Winner)
+	}
+	return ret, nil
+}
+
+// The settings extractContainerLabels produces, merging the labels over the defaults
+func effectiveSettings(ct *containers.Wrapper) []Setting {
+	settings := extractContainerLabels(ct)
+
+	heartbeat := ""
+	if settings.heartbeatToken != "" {
+		heartbeat = "(set)"
+	}
+	idleCheck := ""
+	if settings.idleCheckPath != "" {
+		idleCheck = fmt.Sprintf(":%d%s", settings.idleCheckPort, settings.idleCheckPath)
+	}
+	stopTimeout, stopSignal := "docker default", "docker default"
+	if settings.stopOptions.Timeout != nil {
+		stopTimeout = (time.Duration(*settings.stopOptions.Timeout) * time.Second).String()
+	}
+	if settings.stopOptions.Signal != "" {
+		stopSignal = settings.stopOptions.Signal
+	}
+	hooks := settings.hooks
+	prestart := strings.Join(hooks.prestart, " ")
+	if prestart == "" && hooks.prestartContainer != "" {
+		prestart = "(run to completion)"
+	}
+	poststart := make([]string, 0, len(hooks.poststart))
+	for _, w := range hooks.poststart {
+		if w.url != "" {
+			poststart = append(poststart, w.url)
+		} else {
+			poststart = append(poststart, fmt.Sprintf(":%d%s", w.port, w.path))
+		}
+	}
+	hookTimeout := config.Current().Timeout.String()
+	if hooks.timeout > 0 {
+		hookTimeout = hooks.timeout.String()
+	}
+	idleBytes := "any traffic"
+	if settings.idleBytes > 0 {
+		idleBytes = formatByteRate(settings.idleBytes)
+	}
+
+	values := []struct{ name, value string }{
+		{"stopdelay", settings.currentStopDelay().String()},
+		{"waitforcode", strconv.Itoa(settings.waitForCode)},
+		{"waitforpath", settings.waitForPath},
+		{"waitformethod", settings.waitForMethod},
+		{"needs", strings.Join(settings.needs, ",")},
+		{"activity", settings.activity},
+		{"idlebytes", idleBytes},
+		{"idlenetworks", strings.Join(settings.idleNetworks, ",")},
+		{"idleconnections", strconv.Itoa(settings.idleConns)},
+		{"idlecpu", fmt.Sprintf("%g%%", settings.idleCPU)},
+		{"heartbeattoken", heartbeat},
+		{"idlecheck", idleCheck},
+		{"stoptimeout", stopTimeout},
+		{"stopsignal", stopSignal},
+		{"hooks.prestart", prestart},
+		{"hooks.prestart.container", hooks.prestartContainer},
+		{"hooks.poststart", strings.Join(poststart, ",")},
+		{"hooks.prestop", strings.Join(hooks.prestop, " ")},
+		{"hooks.timeout", hookTimeout},
+	}
+
+	ret := make([]Setting, 0, len(values))
+	for _, v := range values {
+		source := "default"
+		if val, ok := ct.Config(v.name); ok {
+			source = "label"
+			if check := labelCheckers[v.name]; check != nil && check(val) != nil {
+				source = "invalid label"
+			}
+		}
+		ret = append(ret, Setting{Name: v.name, Value: v.value, Source: source})
+	}
+	return ret
+}
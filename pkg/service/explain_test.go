@@ -0,0 +1,41 @@
+package service
+
+import (
+	"testing"
+	"time"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEffectiveSettings(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) {
+		cfg.LabelPrefix = "lazyloader"
+		cfg.StopDelay = 5 * time.Minute
+	})
+
+	ct := &containers.Wrapper{Summary: container.Summary{Labels: map[string]string{
+		"lazyloader.waitforcode":     "204",
+		"lazyloader.idlecpu":         "5",
+		"lazyloader.idleconnections": "many",
+		"lazyloader.heartbeattoken":  "secret",
+		"lazyloader.hooks.poststart": "/warm",
+		"lazyloader.hooks.timeout":   "2m",
+	}}}
+
+	settings := make(map[string]Setting)
+	for _, s := range effectiveSettings(ct) {
+		settings[s.Name] = s
+	}
+	assert.Equal(t, Setting{"stopdelay", "5m0s", "default"}, settings["stopdelay"])
+	assert.Equal(t, Setting{"waitforcode", "204", "label"}, settings["waitforcode"])
+	assert.Equal(t, Setting{"idlecpu", "5%", "label"}, settings["idlecpu"])
+	assert.Equal(t, Setting{"activity", "any", "default"}, settings["activity"]) // Because idlecpu is set
+	assert.Equal(t, Setting{"idleconnections", "-1", "invalid label"}, settings["idleconnections"])
+	assert.Equal(t, Setting{"heartbeattoken", "(set)", "label"}, settings["heartbeattoken"])
+	assert.Equal(t, Setting{"hooks.poststart", ":80/warm", "label"}, settings["hooks.poststart"])
+	assert.Equal(t, Setting{"hooks.timeout", "2m0s", "label"}, settings["hooks.timeout"])
+	assert.Equal(t, Setting{"hooks.prestop", "", "default"}, settings["hooks.prestop"])
+}
@@ -0,0 +1,253 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/sirupsen/logrus"
+)
+
+var hookClient = &http.Client{} // Bounded by the hook timeout
+
+// Lifecycle hooks, from the hooks.* labels
+type hookSettings struct {
+	prestart          []string      // Command exec'd in prestartContainer before starting
+	prestartContainer string        // Container prestart runs in, or (without a command) that's run to completion
+	poststart         []warmup      // Requested before the container counts as ready
+	prestop           []string      // Command exec'd in the container before stopping it
+	timeout           time.Duration // Per hook; 0 is the configured timeout
+}
+
+// A warmup request, to a full URL or else a path (and port) on the container
+type warmup struct {
+	url  string
+	port int
+	path string
+}
+
+func extractHooks(ct *containers.Wrapper) (hooks hookSettings) {
+	if val, ok := ct.Config("hooks.prestart"); ok {
+		hooks.prestart = hookCommand(val)
+	}
+	hooks.prestartContainer, _ = ct.ConfigOrDefault("hooks.prestart.container", "")
+	if hooks.prestart != nil && hooks.prestartContainer == "" {
+		logrus.Warnf("Ignoring prestart hook on %s without hooks.prestart.container to run it in", ct.NameID())
+		hooks.prestart = nil
+	}
+	if val, ok := ct.Config("hooks.prestop"); ok {
+		hooks.prestop = hookCommand(val)
+	}
+
+	targets, _ := ct.ConfigCSV("hooks.poststart", nil)
+	for _, target := range targets {
+		target = strings.TrimSpace(target)
+		switch {
+		case target == "":
+		case strings.Contains(target, "://"):
+			hooks.poststart = append(hooks.poststart, warmup{url: target})
+		default:
+			if port, path := parsePortPath(ct, "hooks.poststart", target); path != "" {
+				hooks.poststart = append(hooks.poststart, warmup{port: port, path: path})
+			}
+		}
+	}
+
+	hooks.timeout, _ = ct.ConfigDuration("hooks.timeout", 0)
+	return
+}
+
+// A hook command: a JSON array (exec form, eg. ["redis-cli", "save"]), or else run with sh -c
+func hookCommand(val string) []string {
+	if strings.HasPrefix(strings.TrimSpace(val), "[") {
+		var cmd []string
+		if err := json.Unmarshal([]byte(val), &cmd); err == nil {
+			return cmd
+		}
+	}
+	return []string{"sh", "-c", val}
+}
+
+// Bounds a hook by its own timeout only. ctx should be one only cancelled by shutdown (like
+// Core.startCtx), not the deadline of whatever ran the hook, or a longer hook timeout would be cut short
+func (s *hookSettings) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := s.timeout
+	if timeout <= 0 {
+		timeout = config.Current().Timeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Runs the prestart command in its container, or else runs that container to completion
+func (s *Core) runPrestartHook(ctx context.Context, ets *ContainerState) error {
+	hooks := &ets.hooks
+	if hooks.prestartContainer == "" {
+		return nil
+	}
+	ctx, cancel := hooks.context(ctx)
+	defer cancel()
+
+	inspect, err := s.client.ContainerInspect(ctx, hooks.prestartContainer)
+	if err != nil {
+		return err
+	}
+	if hooks.prestart != nil {
+		if inspect.State == nil || !inspect.State.Running {
+			return fmt.Errorf("%s is not running", hooks.prestartContainer)
+		}
+		logrus.Infof("Running prestart hook of %s in %s...", ets.name, hooks.prestartContainer)
+		return s.execHook(ctx, inspect.ID, hooks.prestart)
+	}
+
+	logrus.Infof("Running %s before starting %s...", hooks.prestartContainer, ets.name)
+	return s.runToCompletion(ctx, inspect.ID)
+}
+
+// Requests each warmup URL, which must answer without an error status
+func (s *Core) runPoststartHook(ctx context.Context, cid string, ets *ContainerState) error {
+	hooks := &ets.hooks
+	if len(hooks.poststart) == 0 {
+		return nil
+	}
+	ctx, cancel := hooks.context(ctx)
+	defer cancel()
+
+	var ip string
+	for _, w := range hooks.poststart {
+		url := w.url
+		if url == "" {
+			if ip == "" {
+				var err error
+				if ip, err = s.containerIP(ctx, cid); err != nil {
+					return err
+				}
+			}
+			url = "http://" + net.JoinHostPort(ip, strconv.Itoa(w.port)) + w.path
+		}
+
+		logrus.Debugf("Warming up %s with %s", ets.name, url)
+		if err := warmupRequest(ctx, url); err != nil {
+			return fmt.Errorf("warmup %s: %w", url, err)
+		}
+	}
+	return nil
+}
+
+// Runs the prestop command in the container
+func (s *Core) runPrestopHook(ctx context.Context, cid string, ets *ContainerState) error {
+	hooks := &ets.hooks
+	if hooks.prestop == nil {
+		return nil
+	}
+	ctx, cancel := hooks.context(ctx)
+	defer cancel()
+
+	logrus.Infof("Running prestop hook of %s...", ets.name)
+	return s.execHook(ctx, cid, hooks.prestop)
+}
+
+// Runs the prestop hook of a container about to be stopped, reporting (but not stopping on) failure
+func (s *Core) prestop(ctx context.Context, cid string, ets *ContainerState) {
+	if err := s.runPrestopHook(ctx, cid, ets); err != nil {
+		logrus.Errorf("Prestop hook of %s failed: %v", ets.name, err)
+		s.events.Record(Event{Type: EventHookFailed, Container: ets.name, Error: "prestop: " + err.Error()})
+	}
+}
+
+func (s *Core) execHook(ctx context.Context, cid string, cmd []string) error {
+	stdout, stderr, exitCode, err := containers.Exec(ctx, s.client, cid, cmd)
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Hook %v output: %s%s", cmd, stdout, stderr)
+	if exitCode != 0 {
+		return fmt.Errorf("%v exited with %d: %s", cmd, exitCode, lastLine(stderr, stdout))
+	}
+	return nil
+}
+
+// Starts a (one-off) container, unless it's running already, and waits for it to exit successfully
+func (s *Core) runToCompletion(ctx context.Context, cid string) error {
+	inspect, err := s.client.ContainerInspect(ctx, cid)
+	if err != nil {
+		return err
+	}
+	if inspect.State == nil || !inspect.State.Running {
+		if err := s.client.ContainerStart(ctx, cid, container.StartOptions{}); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		inspect, err := s.client.ContainerInspect(ctx, cid)
+		if err != nil {
+			return err
+		}
+		if state := inspect.State; state != nil && !state.Running && !state.Restarting {
+			if state.ExitCode != 0 {
+				return fmt.Errorf("%s exited with %d", strings.TrimPrefix(inspect.Name, "/"), state.ExitCode)
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// First IP address of a container, to reach it directly
+func (s *Core) containerIP(ctx context.Context, cid string) (string, error) {
+	inspect, err := s.client.ContainerInspect(ctx, cid)
+	if err != nil {
+		return "", err
+	}
+	if inspect.NetworkSettings != nil {
+		for _, ep := range inspect.NetworkSettings.Networks {
+			if ep != nil && ep.IPAddress != "" {
+				return ep.IPAddress, nil
+			}
+		}
+	}
+	return "", errors.New("container has no IP address")
+}
+
+func warmupRequest(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return err
+	}
+	resp, err := hookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// Last non-empty line of the first output that has one, to keep errors short
+func lastLine(outputs ...string) string {
+	for _, out := range outputs {
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+			return last
+		}
+	}
+	return "no output"
+}
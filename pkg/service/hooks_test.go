@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractHooks(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.LabelPrefix = "lazyloader" })
+
+	ct := &containers.Wrapper{Summary: container.Summary{Labels: map[string]string{
+		"lazyloader.hooks.prestart":           "./migrate up",
+		"lazyloader.hooks.prestart.container": "db",
+		"lazyloader.hooks.prestop":            `["redis-cli", "save"]`,
+		"lazyloader.hooks.poststart":          "/warm, :9000/cache,https://example.com/ping",
+		"lazyloader.hooks.timeout":            "2m",
+	}}}
+
+	hooks := extractHooks(ct)
+	assert.Equal(t, []string{"sh", "-c", "./migrate up"}, hooks.prestart)
+	assert.Equal(t, "db", hooks.prestartContainer)
+	assert.Equal(t, []string{"redis-cli", "save"}, hooks.prestop)
+	assert.Equal(t, []warmup{{port: 80, path: "/warm"}, {port: 9000, path: "/cache"}, {url: "https://example.com/ping"}}, hooks.poststart)
+	assert.Equal(t, 2*time.Minute, hooks.timeout)
+
+	// A command needs a container to run in
+	ct = &containers.Wrapper{Summary: container.Summary{Labels: map[string]string{
+		"lazyloader.hooks.prestart": "./migrate up",
+	}}}
+	assert.Nil(t, extractHooks(ct).prestart)
+	assert.Equal(t, []LabelProblem{{"lazyloader.hooks.prestart", "needs hooks.prestart.container to run in"}}, lintContainerLabels(ct, nil))
+}
+
+func TestRunToCompletion(t *testing.T) {
+	docker := &fakeDocker{runningFor: 2}
+	core := &Core{client: docker}
+	assert.NoError(t, core.runToCompletion(context.Background(), "migrate"))
+	assert.Equal(t, 1, docker.started)
+
+	docker = &fakeDocker{exitCode: 3}
+	core = &Core{client: docker}
+	assert.EqualError(t, core.runToCompletion(context.Background(), "migrate"), "migrate exited with 3")
+}
+
+func TestPrestartHookFailsStart(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.Timeout = time.Second })
+	events, _ := NewEventLog(10, "")
+	core := &Core{client: &fakeDocker{exitCode: 1}, events: events, startCtx: context.Background()}
+	ets := &ContainerState{name: "app", state: StateStartingDeps}
+	ets.hooks.prestartContainer = "migrate"
+
+	core.startContainerAndDependencies(context.Background(), &containers.Wrapper{}, ets)
+	assert.Equal(t, StateFailed, ets.State())
+
+	failures := core.Events(EventFilter{Type: EventStartFailed})
+	assert.Len(t, failures, 1)
+	assert.Equal(t, "prestart: migrate exited with 1", failures[0].Error)
+}
+
+func TestStartChecksLiveState(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.Timeout = time.Second })
+	events, _ := NewEventLog(10, "")
+	usage, _ := NewUsageTracker("")
+	docker := &fakeDocker{runningFor: 5}
+	core := &Core{client: docker, events: events, usage: usage, startCtx: context.Background()}
+	ets := &ContainerState{name: "app", state: StateStartingDeps}
+
+	// Indexed as running, but stopped since
+	ct := &containers.Wrapper{Summary: container.Summary{ID: "a", State: container.StateRunning}}
+	core.startContainerAndDependencies(context.Background(), ct, ets)
+	assert.Equal(t, 1, docker.started)
+	assert.Equal(t, StateRunning, ets.State())
+}
+
+func TestPoststartHook(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.Timeout = time.Second })
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	core := &Core{}
+	ets := &ContainerState{name: "app"}
+	ets.hooks.poststart = []warmup{{url: srv.URL + "/a"}, {url: srv.URL + "/b"}}
+	assert.NoError(t, core.runPoststartHook(context.Background(), "a", ets))
+	assert.Equal(t, 2, requests)
+
+	ets.hooks.poststart = []warmup{{url: srv.URL + "/broken"}}
+	assert.ErrorContains(t, core.runPoststartHook(context.Background(), "a", ets), "500")
+}
+
+func TestHookTimeoutOutlastsTimeout(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.Timeout = 10 * time.Millisecond })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	core := &Core{}
+	ets := &ContainerState{name: "app"}
+	ets.hooks.poststart = []warmup{{url: srv.URL}}
+	assert.ErrorIs(t, core.runPoststartHook(context.Background(), "a", ets), context.DeadlineExceeded)
+
+	ets.hooks.timeout = time.Second
+	assert.NoError(t, core.runPoststartHook(context.Background(), "a", ets))
+}
+
+func TestHookCommandAndLastLine(t *testing.T) {
+	assert.Equal(t, []string{"sh", "-c", "echo hi"}, hookCommand("echo hi"))
+	assert.Equal(t, []string{"echo", "hi"}, hookCommand(`["echo","hi"]`))
+	assert.Equal(t, []string{"sh", "-c", `["broken`}, hookCommand(`["broken`))
+
+	assert.Equal(t, "failed", lastLine("line\nfailed\n", "out"))
+	assert.Equal(t, "out", lastLine("  ", "out"))
+	assert.Equal(t, "no output", lastLine("", ""))
+}
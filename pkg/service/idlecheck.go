@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const idleCheckTimeout = 5 * time.Second
+
+var idleCheckClient = &http.Client{Timeout: idleCheckTimeout}
+
+// Heartbeat marks the active container with the given heartbeat token as active
+func (s *Core) Heartbeat(token string) (*ContainerState, bool) {
+	if token == "" {
+		return nil, false
+	}
+
+	for _, ct := range s.snapshot() {
+		if ct.heartbeatToken != "" && subtle.ConstantTimeCompare([]byte(ct.heartbeatToken), []byte(token)) == 1 {
+			ct.markActive()
+			return ct, true
+		}
+	}
+	return nil, false
+}
+
+// Ask an idle container whether it's actually busy, via its idlecheck path. If it can't be
+// reached, it's assumed not busy so a broken check can't keep a container up forever
+func (s *Core) isBusy(ctx context.Context, cid string, ct *ContainerState) bool {
+	if ct.idleCheckPath == "" {
+		return false
+	}
+
+	inspect, err := s.client.ContainerInspect(ctx, cid)
+	if err != nil {
+		logrus.Warnf("Unable to inspect %s for idle check: %v", ct.name, err)
+		return false
+	}
+	if inspect.NetworkSettings == nil {
+		return false
+	}
+
+	for _, ep := range inspect.NetworkSettings.Networks {
+		if ep == nil || ep.IPAddress == "" {
+			continue
+		}
+		url := "http://" + net.JoinHostPort(ep.IPAddress, strconv.Itoa(ct.idleCheckPort)) + ct.idleCheckPath
+		busy, err := checkIdleEndpoint(ctx, url)
+		if err != nil {
+			logrus.Debugf("Idle check %s of %s failed: %v", url, ct.name, err)
+			continue
+		}
+		return busy
+	}
+
+	logrus.Warnf("Unable to reach idle check of %s, considering it idle", ct.name)
+	return false
+}
+
+// A container is busy if its idle check answers with "busy"
+func checkIdleEndpoint(ctx context.Context, url string) (busy bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := idleCheckClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return false, fmt.Errorf("reading idle check: %w", err)
+	}
+	return strings.EqualFold(strings.TrimSpace(string(body)), "busy"), nil
+}
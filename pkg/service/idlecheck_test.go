@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeartbeat(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+	ct := &ContainerState{name: "app", containerSettings: containerSettings{heartbeatToken: "secret"}, lastActivity: old}
+	core := &Core{active: map[string]*ContainerState{
+		"a": ct,
+		"b": {name: "other", lastActivity: old},
+	}}
+
+	_, ok := core.Heartbeat("")
+	assert.False(t, ok)
+	_, ok = core.Heartbeat("wrong")
+	assert.False(t, ok)
+	assert.Equal(t, old, ct.lastActivity)
+
+	found, ok := core.Heartbeat("secret")
+	assert.True(t, ok)
+	assert.Equal(t, "app", found.Name())
+	assert.True(t, ct.lastActivity.After(old))
+}
+
+func TestParseIdleCheck(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.LabelPrefix = "lazyloader" })
+
+	tests := []struct {
+		labels       map[string]string
+		ports        []container.Port
+		expectedPort int
+		expectedPath string
+	}{
+		{map[string]string{}, nil, 0, ""},
+		{map[string]string{"lazyloader.idlecheck": "/idle"}, nil, 80, "/idle"},
+		{map[string]string{"lazyloader.idlecheck": "idle"}, []container.Port{{PrivatePort: 3000}}, 3000, "/idle"},
+		{map[string]string{"lazyloader.idlecheck": ":8081/busy"}, nil, 8081, "/busy"},
+		{map[string]string{"lazyloader.idlecheck": ":bad/busy"}, nil, 0, ""},
+		{map[string]string{
+			"lazyloader.idlecheck":                               "/idle",
+			"traefik.http.services.web.loadbalancer.server.port": "8080",
+		}, []container.Port{{PrivatePort: 3000}}, 8080, "/idle"},
+	}
+
+	for _, tt := range tests {
+		ct := &containers.Wrapper{Summary: container.Summary{Labels: tt.labels, Ports: tt.ports}}
+		port, path := parseIdleCheck(ct)
+		assert.Equal(t, tt.expectedPort, port, tt.labels)
+		assert.Equal(t, tt.expectedPath, path, tt.labels)
+	}
+}
+
+func TestCheckIdleEndpoint(t *testing.T) {
+	answer := "busy\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(answer))
+	}))
+	defer srv.Close()
+
+	busy, err := checkIdleEndpoint(context.Background(), srv.URL)
+	assert.NoError(t, err)
+	assert.True(t, busy)
+
+	answer = "idle"
+	busy, err = checkIdleEndpoint(context.Background(), srv.URL)
+	assert.NoError(t, err)
+	assert.False(t, busy)
+
+	_, err = checkIdleEndpoint(context.Background(), "http://127.0.0.1:1/idle")
+	assert.Error(t, err)
+}
@@ -0,0 +1,9 @@
+package service
+
+import "context"
+
+// ActivitySource reports traefik services (optionally with @provider suffix) that had
+// requests since it was last checked
+type ActivitySource interface {
+	ActiveServices(ctx context.Context) ([]string, error)
+}
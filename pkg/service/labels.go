@@ -0,0 +1,278 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+)
+
+// LabelProblem is a mistake in a container's labels, which would otherwise only be logged (or
+// silently ignored)
+type LabelProblem struct {
+	Label   string // Full label, eg. lazyloader.stopdelay
+	Message string
+}
+
+func (s LabelProblem) String() string {
+	return s.Label + ": " + s.Message
+}
+
+// Known sublabels, with a check of their value (nil accepts anything)
+var labelCheckers = map[string]func(val string) error{
+	"stopdelay":            checkDuration,
+	"waitforcode":          checkInt,
+	"waitforpath":          nil,
+	"waitformethod":        checkMethod,
+	"hosts":                nil,
+	"needs":                nil,
+	"idlebytes":            checkByteRate,
+	"idlenetworks":         nil,
+	"idleconnections":      checkInt,
+	"idlecpu":              checkPercent,
+	"activity":             checkActivity,
+	"heartbeattoken":       nil,
+	"idlecheck":            checkIdleCheck,
+	"stoptimeout":          checkDuration,
+	"stopsignal":           checkSignal,
+	"provides":             nil,
+	"provides.delay":       checkDuration,
+	"provides.stoptimeout": checkDuration,
+	"provides.stopsignal":  checkSignal,
+
+	"hooks.prestart":           checkCommand,
+	"hooks.prestart.container": nil,
+	"hooks.poststart":          checkWarmups,
+	"hooks.prestop":            checkCommand,
+	"hooks.timeout":            checkDuration,
+}
+
+// LintLabels checks every qualifying container's labels: unknown keys (with a suggestion), values
+// that can't be parsed, needs that no container provides, and routers without hosts to match.
+// Problems are keyed by container ID; containers without any are left out
+func LintLabels(ctx context.Context, discovery *containers.Discovery) (map[string][]LabelProblem, error) {
+	qualifying, err := discovery.QualifyingContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	providers, err := discovery.ProviderContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	provided := make(map[string]bool)
+	for _, p := range providers {
+		if name, ok := p.Config("provides"); ok {
+			provided[name] = true
+		}
+	}
+
+	ret := make(map[string][]LabelProblem)
+	for i := range qualifying {
+		ct := &qualifying[i]
+		problems := lintContainerLabels(ct, provided)
+
+		if hosts, patterns, err := discovery.ContainerHosts(ctx, ct.ID); err != nil {
+			return nil, err
+		} else if len(hosts) == 0 && len(patterns) == 0 {
+			problems = append(problems, LabelProblem{config.SubLabel("hosts"), "no hosts, so it can't be started by a request"})
+		}
+
+		if len(problems) > 0 {
+			ret[ct.ID] = problems
+		}
+	}
+	return ret, nil
+}
+
+// Checks a container's labels on their own; provided is the set of dependency names provided
+func lintContainerLabels(ct *containers.Wrapper, provided map[string]bool) []LabelProblem {
+	labels := ct.ConfigLabels()
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	problems := make([]LabelProblem, 0)
+	for _, key := range keys {
+		check, known := labelCheckers[key]
+		switch {
+		case !known:
+			msg := "unknown label"
+			if suggestion := closestLabel(key); suggestion != "" {
+				msg += fmt.Sprintf(", did you mean %s?", config.SubLabel(suggestion))
+			}
+			problems = append(problems, LabelProblem{config.SubLabel(key), msg})
+		case check != nil:
+			if err := check(labels[key]); err != nil {
+				problems = append(problems, LabelProblem{config.SubLabel(key), fmt.Sprintf("invalid value %q: %v", labels[key], err)})
+			}
+		}
+	}
+
+	needs, _ := ct.ConfigCSV("needs", nil)
+	for _, need := range needs {
+		if !provided[need] {
+			problems = append(problems, LabelProblem{config.SubLabel("needs"), fmt.Sprintf("no container provides %q", need)})
+		}
+	}
+
+	if networks, _ := ct.ConfigCSV("idlenetworks", nil); len(networks) > 0 && ct.NetworkSettings != nil {
+		if _, unresolved := interfacesForNetworks(networks, ct.NetworkSettings.Networks); len(unresolved) > 0 {
+			problems = append(problems, LabelProblem{config.SubLabel("idlenetworks"), fmt.Sprintf(
+				"can't tell which interfaces are %v, so all traffic counts as activity; set the %s driver option, or list interface names",
+				unresolved, ifnameDriverOpt)})
+		}
+	}
+
+	if _, ok := ct.Config("hooks.prestart"); ok {
+		if _, ok := ct.Config("hooks.prestart.container"); !ok {
+			problems = append(problems, LabelProblem{config.SubLabel("hooks.prestart"), "needs hooks.prestart.container to run in"})
+		}
+	}
+
+	// With explicit hosts, router rules aren't used
+	if _, hasHosts := ct.Config("hosts"); !hasHosts {
+		for _, label := range containers.UnmatchableRouters(ct) {
+			problems = append(problems, LabelProblem{label, "rule has no Host() or HostRegexp() to match requests by"})
+		}
+	}
+	return problems
+}
+
+// The known label closest to key, if it's close enough to be a typo
+func closestLabel(key string) string {
+	best, bestDist := "", 3 // At most 2 edits
+	for known := range labelCheckers {
+		if dist := editDistance(key, known); dist < bestDist || (dist == bestDist && known < best) {
+			best, bestDist = known, dist
+		}
+	}
+	return best
+}
+
+// Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func checkDuration(val string) error {
+	_, err := time.ParseDuration(val)
+	return err
+}
+
+func checkInt(val string) error {
+	_, err := strconv.Atoi(val)
+	return err
+}
+
+func checkPercent(val string) error {
+	_, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(val, "%")), 64)
+	return err
+}
+
+func checkByteRate(val string) error {
+	_, err := containers.ParseByteRate(val)
+	return err
+}
+
+func checkMethod(val string) error {
+	switch val {
+	case http.MethodHead, http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return nil
+	}
+	return errors.New("not an HTTP method")
+}
+
+func checkActivity(val string) error {
+	switch val {
+	case activityNetwork, activityCPU, activityAny, activityAll, activityRequests:
+		return nil
+	}
+	return fmt.Errorf("must be one of %s, %s, %s, %s or %s", activityNetwork, activityCPU, activityAny, activityAll, activityRequests)
+}
+
+// Signals docker accepts by name, with or without the SIG prefix
+var signalNames = []string{
+	"HUP", "INT", "QUIT", "ILL", "TRAP", "ABRT", "BUS", "FPE", "KILL", "USR1", "SEGV", "USR2", "PIPE",
+	"ALRM", "TERM", "STKFLT", "CHLD", "CONT", "STOP", "TSTP", "TTIN", "TTOU", "URG", "XCPU", "XFSZ",
+	"VTALRM", "PROF", "WINCH", "IO", "PWR", "SYS",
+}
+
+func checkSignal(val string) error {
+	if n, err := strconv.Atoi(val); err == nil {
+		if n <= 0 || n > 64 {
+			return errors.New("signal number out of range")
+		}
+		return nil
+	}
+	name := strings.TrimPrefix(strings.ToUpper(val), "SIG")
+	if slices.Contains(signalNames, name) || strings.HasPrefix(name, "RTMIN") || strings.HasPrefix(name, "RTMAX") {
+		return nil
+	}
+	return errors.New("unknown signal")
+}
+
+// An exec-form command must be a valid JSON array
+func checkCommand(val string) error {
+	if !strings.HasPrefix(strings.TrimSpace(val), "[") {
+		return nil
+	}
+	var cmd []string
+	if err := json.Unmarshal([]byte(val), &cmd); err != nil {
+		return fmt.Errorf("not a JSON array of strings: %w", err)
+	}
+	if len(cmd) == 0 {
+		return errors.New("empty command")
+	}
+	return nil
+}
+
+func checkWarmups(val string) error {
+	for _, target := range strings.Split(val, ",") {
+		target = strings.TrimSpace(target)
+		if strings.Contains(target, "://") {
+			if _, err := url.Parse(target); err != nil {
+				return err
+			}
+		} else if err := checkIdleCheck(target); err != nil {
+			return fmt.Errorf("%s: %w", target, err)
+		}
+	}
+	return nil
+}
+
+func checkIdleCheck(val string) error {
+	if !strings.HasPrefix(val, ":") {
+		return nil
+	}
+	portStr, _, _ := strings.Cut(val[1:], "/")
+	_, err := strconv.Atoi(portStr)
+	return err
+}
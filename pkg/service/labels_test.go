@@ -0,0 +1,89 @@
+package service
+
+import (
+	"testing"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintContainerLabels(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.LabelPrefix = "lazyloader" })
+
+	ct := &containers.Wrapper{Summary: container.Summary{Labels: map[string]string{
+		"lazyloader":                  "true",
+		"lazyloader.stopdelay":        "5",
+		"lazyloader.waitforcode":      "200,204",
+		"lazyloader.stopdlay":         "5m",
+		"lazyloader.idlebytes":        "10KiB/min",
+		"lazyloader.activity":         "cpus",
+		"lazyloader.needs":            "db,cache",
+		"lazyloader.somethingelse":    "x",
+		"traefik.http.routers.a.rule": "Host(`a.com`)",
+		"traefik.http.routers.b.rule": "PathPrefix(`/b`)",
+	}}}
+
+	problems := lintContainerLabels(ct, map[string]bool{"db": true})
+	assert.Equal(t, []LabelProblem{
+		{"lazyloader.activity", `invalid value "cpus": must be one of network, cpu, any, all or requests`},
+		{"lazyloader.somethingelse", "unknown label"},
+		{"lazyloader.stopdelay", `invalid value "5": time: missing unit in duration "5"`},
+		{"lazyloader.stopdlay", "unknown label, did you mean lazyloader.stopdelay?"},
+		{"lazyloader.waitforcode", `invalid value "200,204": strconv.Atoi: parsing "200,204": invalid syntax`},
+		{"lazyloader.needs", `no container provides "cache"`},
+		{"traefik.http.routers.b.rule", "rule has no Host() or HostRegexp() to match requests by"},
+	}, problems)
+}
+
+func TestLintUnmappedIdleNetworks(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.LabelPrefix = "lazyloader" })
+
+	ct := &containers.Wrapper{Summary: container.Summary{
+		Labels: map[string]string{"lazyloader": "true", "lazyloader.idlenetworks": "proxy"},
+		NetworkSettings: &container.NetworkSettingsSummary{Networks: map[string]*network.EndpointSettings{
+			"proxy": {}, "backend": {},
+		}},
+	}}
+	problems := lintContainerLabels(ct, nil)
+	if assert.Len(t, problems, 1) {
+		assert.Equal(t, "lazyloader.idlenetworks", problems[0].Label)
+		assert.Contains(t, problems[0].Message, "[proxy]")
+	}
+
+	ct.NetworkSettings.Networks["proxy"].DriverOpts = map[string]string{ifnameDriverOpt: "eth1"}
+	assert.Empty(t, lintContainerLabels(ct, nil))
+}
+
+func TestLintContainerLabelsValid(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.LabelPrefix = "lazyloader" })
+
+	ct := &containers.Wrapper{Summary: container.Summary{Labels: map[string]string{
+		"lazyloader":                  "true",
+		"lazyloader.stopdelay":        "5m",
+		"lazyloader.idlecpu":          "5%",
+		"lazyloader.idlecheck":        ":8080/idle",
+		"lazyloader.hosts":            "a.com",
+		"traefik.http.routers.b.rule": "PathPrefix(`/b`)", // Unused with explicit hosts
+	}}}
+
+	assert.Empty(t, lintContainerLabels(ct, nil))
+}
+
+func TestClosestLabel(t *testing.T) {
+	assert.Equal(t, "stopdelay", closestLabel("stopdelya"))
+	assert.Equal(t, "idlecpu", closestLabel("idlecpus"))
+	assert.Equal(t, "provides.delay", closestLabel("provide.delay"))
+	assert.Equal(t, "", closestLabel("unrelated"))
+}
+
+func TestCheckSignal(t *testing.T) {
+	for _, val := range []string{"SIGINT", "quit", "TERM", "9", "SIGRTMIN+3"} {
+		assert.NoError(t, checkSignal(val), val)
+	}
+	for _, val := range []string{"SIGFOO", "0", "100", ""} {
+		assert.Error(t, checkSignal(val), val)
+	}
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+)
+
+// Updates the config for the duration of a test
+func setConfig(t *testing.T, fn func(cfg *config.ConfigModel)) {
+	t.Helper()
+	prev := config.Current()
+	t.Cleanup(func() { config.Apply(prev) })
+	config.Update(fn)
+}
+
+// fakeDocker lists a fixed set of containers, and runs a one-off container that exits after a
+// number of inspects
+type fakeDocker struct {
+	containers.Host
+	containers []container.Summary
+	exitCode   int
+	runningFor int // Inspects until it exits
+	started    int
+}
+
+func (s *fakeDocker) ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error) {
+	return s.containers, nil
+}
+
+func (s *fakeDocker) ContainerStart(ctx context.Context, id string, opt container.StartOptions) error {
+	s.started++
+	return nil
+}
+
+func (s *fakeDocker) ContainerInspect(ctx context.Context, id string) (container.InspectResponse, error) {
+	running := s.started > 0 && s.runningFor > 0
+	if running {
+		s.runningFor--
+	}
+	return container.InspectResponse{ContainerJSONBase: &container.ContainerJSONBase{
+		ID:    id,
+		Name:  "/" + id,
+		State: &container.State{Running: running, ExitCode: s.exitCode},
+	}}, nil
+}
+
+func (s *fakeDocker) ContainerExecAttach(ctx context.Context, execID string, config container.ExecAttachOptions) (types.HijackedResponse, error) {
+	panic("not used")
+}
+
+func (s *fakeDocker) Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error) {
+	panic("not used")
+}
@@ -2,30 +2,78 @@ package service
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 	"traefik-lazyload/pkg/config"
 	"traefik-lazyload/pkg/containers"
 
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/sirupsen/logrus"
 )
 
+// Core manages the lifecycle of lazyloaded containers. mux only guards the set of containers (and
+// sources), and is never held during docker calls; each container guards its own state
 type Core struct {
-	mux  sync.Mutex
-	term chan bool
+	mux     sync.Mutex
+	closing bool // Shutting down, so no more starts
+
+	stopPolling  context.CancelFunc
+	polling      sync.WaitGroup
+	pollRate     chan time.Duration // Changes the poll thread's rate
+	startCtx     context.Context    // Parent of in-flight starts and hooks, cancelled if they outlast shutdown
+	cancelStarts context.CancelFunc
+	starting     sync.WaitGroup
 
 	client    containers.Host
 	discovery *containers.Discovery
+	sources   []ActivitySource
+	stats     statsCollector
+	events    *EventLog
+	usage     *UsageTracker
 
-	active map[string]*ContainerState // cid -> state
+	active     map[string]*ContainerState // cid -> state, for all containers not Stopped
+	wouldStart map[string]time.Time       // cid -> when a dry-run start was recorded, so it's reported once
 }
 
 func New(client *client.Client, discovery *containers.Discovery, pollRate time.Duration) (*Core, error) {
+	ret, err := newCore(client, discovery)
+	if err != nil {
+		return nil, err
+	}
+
+	pollCtx, stopPolling := context.WithCancel(context.Background())
+	ret.stopPolling = stopPolling
+	ret.Poll(pollCtx) // initial force-poll to update
+
+	ret.polling.Add(1)
+	go ret.pollThread(pollCtx, pollRate)
+
+	return ret, nil
+}
+
+// NewWithoutPolling makes a core for one-off commands. It only takes stock of the running
+// containers, and never polls, so it doesn't stop idle containers as a side effect
+func NewWithoutPolling(client *client.Client, discovery *containers.Discovery) (*Core, error) {
+	ret, err := newCore(client, discovery)
+	if err != nil {
+		return nil, err
+	}
+	ret.stopPolling = func() {}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Current().Timeout)
+	defer cancel()
+	ret.checkForNewContainersSync(ctx)
+	return ret, nil
+}
+
+func newCore(client *client.Client, discovery *containers.Discovery) (*Core, error) {
 	// Test client and report
 	if info, err := client.Info(context.Background()); err != nil {
 		return nil, err
@@ -33,33 +81,95 @@ func New(client *client.Client, discovery *containers.Discovery, pollRate time.D
 		logrus.Infof("Connected docker to %s (v%s)", info.Name, info.ServerVersion)
 	}
 
+	events, err := NewEventLog(config.Current().EventHistory, config.Current().EventLog)
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := NewUsageTracker(config.Current().UsageFile)
+	if err != nil {
+		return nil, err
+	}
+
 	// Make core
 	ret := &Core{
-		client:    client,
-		discovery: discovery,
-		active:    make(map[string]*ContainerState),
-		term:      make(chan bool),
+		client:     client,
+		discovery:  discovery,
+		active:     make(map[string]*ContainerState),
+		wouldStart: make(map[string]time.Time),
+		stats:      &dockerStats{client},
+		events:     events,
+		usage:      usage,
+		pollRate:   make(chan time.Duration, 1),
+	}
+	ret.startCtx, ret.cancelStarts = context.WithCancel(context.Background())
+	if config.Current().DryRun {
+		logrus.Warn("Dry run: containers will not be started or stopped, only logged")
+	}
+	if config.Current().ProcRoot != "" {
+		logrus.Infof("Reading container stats from %s and %s", config.Current().ProcRoot, config.Current().CgroupRoot)
+		ret.stats = newProcStats(client, config.Current().ProcRoot, config.Current().CgroupRoot, ret.stats)
 	}
+	return ret, nil
+}
 
-	ret.Poll() // initial force-poll to update
-	go ret.pollThread(pollRate)
+// Shutdown stops polling and waits for in-flight starts to finish, cancelling them if ctx is done
+// first. No more containers can be started after
+func (s *Core) Shutdown(ctx context.Context) error {
+	s.mux.Lock()
+	s.closing = true
+	s.mux.Unlock()
 
-	return ret, nil
+	s.stopPolling()
+
+	done := make(chan struct{})
+	go func() {
+		s.polling.Wait()
+		s.starting.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		logrus.Warn("Cancelling in-flight container starts...")
+		s.cancelStarts()
+		<-done
+		err = ctx.Err()
+	}
+
+	if saveErr := s.usage.Save(); saveErr != nil {
+		logrus.Warnf("Error saving usage: %v", saveErr)
+	}
+	return err
 }
 
+// Close shuts down (if not already), and releases the docker client
 func (s *Core) Close() error {
-	s.mux.Lock()
-	defer s.mux.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), config.Current().ShutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		logrus.Warnf("Error shutting down: %v", err)
+	}
 
-	s.term <- true
+	if err := s.events.Close(); err != nil {
+		logrus.Warnf("Error closing event log: %v", err)
+	}
 	return s.client.Close()
 }
 
-func (s *Core) StartHost(hostname string) (*ContainerState, error) {
+// StartHost starts the container serving hostname, unless it's already started (or starting).
+// `from` is recorded in the event history
+func (s *Core) StartHost(hostname string, from Requester) (*ContainerState, error) {
 	s.mux.Lock()
-	defer s.mux.Unlock()
+	closing := s.closing
+	s.mux.Unlock()
+	if closing {
+		return nil, ErrShuttingDown
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), config.Model.Timeout)
+	ctx, cancel := context.WithTimeout(s.startCtx, config.Current().Timeout)
 
 	ct, err := s.discovery.FindContainerByHostname(ctx, hostname)
 	if err != nil {
@@ -68,62 +178,258 @@ func (s *Core) StartHost(hostname string) (*ContainerState, error) {
 		return nil, err
 	}
 
-	if ets, exists := s.active[ct.ID]; exists {
-		logrus.Debugf("Asked to start host, but we already think it's started: %s", ets.name)
+	s.mux.Lock()
+	if s.closing { // Began shutting down during the lookup
+		s.mux.Unlock()
+		cancel()
+		return nil, ErrShuttingDown
+	}
+	ets, exists := s.active[ct.ID]
+	if !exists {
+		ets = newStateFromContainer(ct)
+		if s.wouldStartPendingLocked(ct.ID, ets) {
+			s.mux.Unlock()
+			cancel()
+			logrus.Debugf("Dry run: already reported that %s would start", ets.name)
+			return ets, nil
+		}
+		s.active[ct.ID] = ets
+	}
+	starting := ets.transitionFrom(StateStopped, StateStartingDeps)
+	if starting {
+		s.starting.Add(1)
+	}
+	s.mux.Unlock()
+
+	if !starting {
+		logrus.Debugf("Asked to start host, but it's already %s: %s", ets.State(), ets.name)
 		cancel()
 		return ets, nil
 	}
 
-	// add to active pool
 	logrus.Infof("Starting container for %s...", hostname)
-	ets := newStateFromContainer(ct)
-	s.active[ct.ID] = ets
-	ets.pinned = true // pin while starting
-
+	s.events.Record(Event{Type: EventStartRequested, Container: ets.name, Host: hostname, Requester: from})
 	go func() {
-		defer func() {
-			cancel()
-			s.mux.Lock()
-			ets.pinned = false
-			ets.lastActivity = time.Now()
-			s.mux.Unlock()
-		}()
-		if err := s.startDependencyFor(ctx, ets.needs, ct.NameID()); err != nil {
-			logrus.Errorf("Failed to start dependencies for %s: %v", ct.NameID(), err)
-		}
-		if err := s.startContainerSync(ctx, ct); err != nil {
-			logrus.Errorf("Failed to start container %s: %v", ct.NameID(), err)
-		}
+		defer s.starting.Done()
+		defer cancel()
+		s.startContainerAndDependencies(ctx, ct, ets)
 	}()
 
 	return ets, nil
 }
 
-// Stop all running containers pined with the configured label
-func (s *Core) StopAll() {
+// Runs a container through StartingDeps, Starting and WaitingReady to Running (or Failed). Gives
+// up if something else (eg. StopAll) moves the container out of those states meanwhile
+func (s *Core) startContainerAndDependencies(ctx context.Context, ct *containers.Wrapper, ets *ContainerState) {
+	requested := time.Now()
+	failed := func(err error) {
+		if ets.transition(StateFailed) {
+			s.events.Record(Event{Type: EventStartFailed, Container: ets.name, Error: err.Error()})
+		}
+	}
+
+	if err := s.startDependencyFor(ctx, ets.needs, ct.NameID()); err != nil {
+		logrus.Errorf("Failed to start dependencies for %s: %v", ct.NameID(), err)
+	}
+
+	if !ets.transition(StateStarting) {
+		return
+	}
+	ct = s.withLiveState(ctx, ct)
+	if !ct.IsRunning() && !config.Current().DryRun {
+		if err := s.runPrestartHook(s.startCtx, ets); err != nil {
+			logrus.Errorf("Prestart hook of %s failed: %v", ct.NameID(), err)
+			failed(fmt.Errorf("prestart: %w", err))
+			return
+		}
+	}
+
+	// Hooks have their own timeout, so starting the container gets all of the configured one
+	ctx, cancel := context.WithTimeout(s.startCtx, config.Current().Timeout)
+	defer cancel()
+	if err := s.startContainerSync(ctx, ct); errors.Is(err, errDryRun) {
+		s.mux.Lock()
+		s.wouldStart[ct.ID] = time.Now()
+		s.mux.Unlock()
+		s.remove(ct.ID, ets)
+		return
+	} else if err != nil {
+		logrus.Errorf("Failed to start container %s: %v", ct.NameID(), err)
+		failed(err)
+		return
+	}
+
+	if !ets.transition(StateWaitingReady) {
+		return
+	}
+	if err := s.waitForReady(ctx, ct.ID); err != nil {
+		if s.startCtx.Err() != nil {
+			// Cancelled by shutdown, not a failure of the container
+			logrus.Warnf("Stopped waiting for %s to become ready", ct.NameID())
+			return
+		}
+		logrus.Errorf("Container %s never became ready: %v", ct.NameID(), err)
+		failed(err)
+		return
+	}
+	if err := s.runPoststartHook(s.startCtx, ct.ID, ets); err != nil {
+		logrus.Errorf("Poststart hook of %s failed: %v", ct.NameID(), err)
+		failed(fmt.Errorf("poststart: %w", err))
+		// Running but not warmed up, so stop it; otherwise the next poll would find it running
+		// after all, and count it as started. The next request tries again
+		if err := s.client.ContainerStop(ctx, ct.ID, ets.stopOptions); err != nil {
+			logrus.Warnf("Error stopping %s after its poststart hook failed: %v", ct.NameID(), err)
+		}
+		return
+	}
+
+	if ets.transition(StateRunning) {
+		took := time.Since(requested).Round(time.Millisecond)
+		s.events.Record(Event{Type: EventReady, Container: ets.name, Duration: took.String()})
+		s.usage.ColdStarted(ets.container, ets.labels, took)
+	}
+}
+
+// SetPollRate changes how often to poll, eg. after the config is reloaded
+func (s *Core) SetPollRate(rate time.Duration) {
+	// Only the latest rate matters
+	select {
+	case <-s.pollRate:
+	default:
+	}
+	s.pollRate <- rate
+}
+
+// AddActivitySource registers a source that is checked for request activity on each poll
+func (s *Core) AddActivitySource(src ActivitySource) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
+	s.sources = append(s.sources, src)
+}
 
-	ctx := context.Background()
+// RecordRequest marks the container serving a request seen by traefik (eg. in the access log) as
+// active. Returns false if the request isn't for an active, managed container
+func (s *Core) RecordRequest(hostname, router, service string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Current().Timeout)
+	defer cancel()
 
+	ct, err := s.discovery.FindContainerByRequest(ctx, hostname, router, service)
+	if err != nil {
+		return false
+	}
+	return s.MarkActive(ct.ID)
+}
+
+// MarkActive resets the idle timer of an active container
+func (s *Core) MarkActive(cid string) bool {
+	s.mux.Lock()
+	ct, ok := s.active[cid]
+	s.mux.Unlock()
+
+	if !ok {
+		return false
+	}
+	ct.markActive()
+	return true
+}
+
+// Returns the state of the container serving hostname, and whether it is running and done starting.
+// Never starts a container
+func (s *Core) HostReady(hostname string) (*ContainerState, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Current().Timeout)
+	defer cancel()
+
+	ct, err := s.discovery.FindContainerByHostname(ctx, hostname)
+	if err != nil {
+		return nil, false
+	}
+
+	s.mux.Lock()
+	ets, exists := s.active[ct.ID]
+	s.mux.Unlock()
+
+	if !exists {
+		return nil, false
+	}
+	return ets, ets.State().Ready()
+}
+
+// Stop all running containers pined with the configured label, giving up when ctx is done
+func (s *Core) StopAll(ctx context.Context) {
 	logrus.Info("Stopping all containers...")
-	for cid, ct := range s.active {
+	for cid, ct := range s.snapshot() {
+		if ctx.Err() != nil {
+			logrus.Warnf("Gave up stopping containers: %v", ctx.Err())
+			return
+		}
+		prev, stopping := ct.beginStop()
+		if !stopping {
+			continue // Already stopping
+		}
+		if err := s.dryRun(Event{Type: EventWouldStop, Container: ct.name}); err != nil {
+			ct.abortStop(prev)
+			continue
+		}
 		logrus.Infof("Stopping %s...", ct.name)
-		if err := s.client.ContainerStop(ctx, cid, container.StopOptions{}); err != nil {
+		s.prestop(ctx, cid, ct)
+		if err := s.client.ContainerStop(ctx, cid, ct.stopOptions); err != nil {
 			logrus.Warnf("Error stopping %s: %v", ct.name, err)
+			ct.abortStop(prev)
 		} else {
-			delete(s.active, cid)
+			s.remove(cid, ct)
+			s.events.Record(Event{Type: EventEvicted, Container: ct.name})
 		}
 	}
 }
 
+// Stop stops a managed container, found by hostname or else by name or ID, and then any
+// dependencies no longer needed
+func (s *Core) Stop(ctx context.Context, target string) (*ContainerState, error) {
+	ct, err := s.discovery.FindContainerByHostname(ctx, target)
+	if errors.Is(err, containers.ErrNotFound) {
+		ct, err = s.findContainerByName(ctx, target)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.mux.Lock()
+	ets, exists := s.active[ct.ID]
+	s.mux.Unlock()
+	if !exists {
+		return nil, ErrNotRunning
+	}
+
+	if !ets.transition(StateStopping) {
+		return ets, fmt.Errorf("%s is %s", ets.name, ets.State())
+	}
+	if !s.stopContainerAndDependencies(ctx, ct.ID, ets) {
+		return ets, fmt.Errorf("unable to stop %s", ets.name)
+	}
+	s.events.Record(Event{Type: EventEvicted, Container: ets.name})
+	return ets, nil
+}
+
+// Find a running lazyload container by name, or ID (prefix)
+func (s *Core) findContainerByName(ctx context.Context, name string) (*containers.Wrapper, error) {
+	cts, err := s.discovery.FindAllLazyload(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	for i := range cts {
+		if cts[i].Name() == name || strings.HasPrefix(cts[i].ID, name) {
+			return &cts[i], nil
+		}
+	}
+	return nil, containers.ErrNotFound
+}
+
 // Returns all actively managed containers
 func (s *Core) ActiveContainers() []*ContainerState {
-	s.mux.Lock()
-	defer s.mux.Unlock()
+	active := s.snapshot()
 
-	ret := make([]*ContainerState, 0, len(s.active))
-	for _, item := range s.active {
+	ret := make([]*ContainerState, 0, len(active))
+	for _, item := range active {
 		ret = append(ret, item)
 	}
 	sort.Slice(ret, func(i, j int) bool {
@@ -132,10 +438,101 @@ func (s *Core) ActiveContainers() []*ContainerState {
 	return ret
 }
 
+// Events returns the recent lifecycle events matching the filter, newest first
+func (s *Core) Events(filter EventFilter) []Event {
+	return s.events.Recent(filter)
+}
+
+// DryRunActions returns the most recent actions skipped in dry-run mode, newest first
+func (s *Core) DryRunActions(limit int) []Event {
+	return s.events.recent(limit, func(e *Event) bool {
+		return e.Type == EventWouldStart || e.Type == EventWouldStop
+	})
+}
+
+// In dry-run mode, records the action that would have been taken instead, and returns errDryRun
+func (s *Core) dryRun(e Event) error {
+	if !config.Current().DryRun {
+		return nil
+	}
+
+	action := "start"
+	if e.Type == EventWouldStop {
+		action = "stop"
+	}
+	logrus.Infof("Dry run: would %s %s", action, e.Container)
+	s.events.Record(e)
+	return errDryRun
+}
+
+// Usage returns the usage report, per container or grouped by the value of a label
+func (s *Core) Usage(groupBy string) []UsageReport {
+	return s.usage.Report(groupBy)
+}
+
+// Copy of the managed containers, to work on without holding the lock
+func (s *Core) snapshot() map[string]*ContainerState {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	ret := make(map[string]*ContainerState, len(s.active))
+	for cid, ct := range s.active {
+		ret[cid] = ct
+	}
+	return ret
+}
+
+// Whether a dry-run start of the container was reported within its stop delay, so it's reported
+// once per stop delay (as would-stops are) rather than on every request. Needs s.mux
+func (s *Core) wouldStartPendingLocked(cid string, ets *ContainerState) bool {
+	reported, ok := s.wouldStart[cid]
+	if !ok {
+		return false
+	}
+	if !config.Current().DryRun || time.Since(reported) >= ets.currentStopDelay() {
+		delete(s.wouldStart, cid)
+		return false
+	}
+	return true
+}
+
+// Marks a container as stopped and stops tracking it
+func (s *Core) remove(cid string, ct *ContainerState) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	// Together, so StartHost never finds a stopped container that's about to be forgotten
+	ct.transition(StateStopped)
+	if s.active[cid] == ct {
+		delete(s.active, cid)
+	}
+	s.usage.Stopped(ct.container)
+}
+
+// A copy of an indexed container with its current state, as the index may be stale (eg. stopped
+// outside the lazyloader since). Keeps the indexed state if the container can't be inspected
+func (s *Core) withLiveState(ctx context.Context, ct *containers.Wrapper) *containers.Wrapper {
+	inspect, err := s.client.ContainerInspect(ctx, ct.ID)
+	if err != nil || inspect.ContainerJSONBase == nil || inspect.State == nil {
+		logrus.Debugf("Unable to inspect %s, assuming it's %s: %v", ct.NameID(), ct.State, err)
+		return ct
+	}
+	live := *ct
+	if inspect.State.Running {
+		live.State = container.StateRunning
+	} else {
+		live.State = container.StateExited
+	}
+	return &live
+}
+
 func (s *Core) startContainerSync(ctx context.Context, ct *containers.Wrapper) error {
 	if ct.IsRunning() {
 		return nil
 	}
+	if err := s.dryRun(Event{Type: EventWouldStart, Container: ct.NameID()}); err != nil {
+		return err
+	}
 
 	if err := s.client.ContainerStart(ctx, ct.ID, container.StartOptions{}); err != nil {
 		logrus.Warnf("Error starting container %s: %s", ct.NameID(), err)
@@ -146,6 +543,35 @@ func (s *Core) startContainerSync(ctx context.Context, ct *containers.Wrapper) e
 	return nil
 }
 
+// Waits for a started container to be running, and healthy if it has a healthcheck
+func (s *Core) waitForReady(ctx context.Context, cid string) error {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		inspect, err := s.client.ContainerInspect(ctx, cid)
+		if err != nil {
+			return err
+		}
+		if state := inspect.State; state != nil {
+			switch {
+			case state.Status == container.StateExited || state.Status == container.StateDead:
+				return fmt.Errorf("container %s (exit code %d)", state.Status, state.ExitCode)
+			case state.Health != nil && state.Health.Status == container.Unhealthy:
+				return errors.New("container unhealthy")
+			case state.Running && (state.Health == nil || state.Health.Status != container.Starting):
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
 func (s *Core) startDependencyFor(ctx context.Context, needs []string, forContainer string) error {
 	for _, dep := range needs {
 		providers, err := s.discovery.FindDepProvider(ctx, dep)
@@ -162,9 +588,12 @@ func (s *Core) startDependencyFor(ctx context.Context, needs []string, forContai
 				if !provider.IsRunning() {
 					logrus.Infof("Starting dependency for %s: %s", forContainer, provider.NameID())
 
-					if err := s.startContainerSync(ctx, &provider); err != nil {
+					if err := s.startContainerSync(ctx, &provider); errors.Is(err, errDryRun) {
+						continue
+					} else if err != nil {
 						return err
 					}
+					s.events.Record(Event{Type: EventDependencyStarted, Container: forContainer, Dependency: provider.NameID()})
 
 					delay, _ := provider.ConfigDuration("provides.delay", 2*time.Second)
 					logrus.Debugf("Delaying %s to start %s", delay.String(), dep)
@@ -186,7 +615,7 @@ func (s *Core) stopDependenciesFor(ctx context.Context, cid string, cts *Contain
 		deps[dep] = false
 	}
 
-	for activeId, active := range s.active {
+	for activeId, active := range s.snapshot() {
 		if activeId != cid { // ignore self
 			for _, need := range active.needs {
 				deps[need] = true
@@ -206,8 +635,11 @@ func (s *Core) stopDependenciesFor(ctx context.Context, cid string, cts *Contain
 			} else {
 				for _, ct := range containers {
 					if ct.IsRunning() {
+						if err := s.dryRun(Event{Type: EventWouldStop, Container: ct.NameID(), Dependency: dep}); err != nil {
+							continue
+						}
 						logrus.Infof("Stopping %s...", ct.NameID())
-						if err := s.client.ContainerStop(ctx, ct.ID, container.StopOptions{}); err != nil {
+						if err := s.client.ContainerStop(ctx, ct.ID, stopOptionsFor(&ct, "provides.")); err != nil {
 							logrus.Warnf("Error stopping %s: %v", ct.NameID(), err)
 						}
 					}
@@ -220,132 +652,240 @@ func (s *Core) stopDependenciesFor(ctx context.Context, cid string, cts *Contain
 }
 
 // Ticker loop that will check internal state against docker state (Call Poll)
-func (s *Core) pollThread(rate time.Duration) {
+func (s *Core) pollThread(ctx context.Context, rate time.Duration) {
+	defer s.polling.Done()
+
 	ticker := time.NewTicker(rate)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-s.term:
+		case <-ctx.Done():
 			return
+		case newRate := <-s.pollRate:
+			if newRate != rate {
+				logrus.Infof("Polling every %s", newRate)
+				rate = newRate
+				ticker.Reset(rate)
+			}
 		case <-ticker.C:
-			s.Poll()
+			s.Poll(ctx)
 		}
 	}
 }
 
 // Initiate a thread-safe state-update, adding containers to the system, or
 // stopping idle containers
-// Will normally happen in the background with the pollThread
-func (s *Core) Poll() {
-	ctx, cancel := context.WithTimeout(context.Background(), config.Model.Timeout)
+// Will normally happen in the background with the pollThread. Cancelling ctx (as Shutdown does)
+// ends it early
+func (s *Core) Poll(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().Timeout)
 	defer cancel()
 
 	s.checkForNewContainersSync(ctx)
+	s.checkActivitySources(ctx)
 	s.watchForInactivitySync(ctx)
 }
 
+func (s *Core) checkActivitySources(ctx context.Context) {
+	s.mux.Lock()
+	sources := s.sources
+	s.mux.Unlock()
+
+	for _, src := range sources {
+		services, err := src.ActiveServices(ctx)
+		if err != nil {
+			logrus.Warnf("Error checking activity source: %v", err)
+			continue
+		}
+		for _, service := range services {
+			if s.RecordRequest("", "", service) {
+				logrus.Debugf("Service %s had requests, marked active", service)
+			}
+		}
+	}
+}
+
 func (s *Core) checkForNewContainersSync(ctx context.Context) {
-	cts, err := s.discovery.FindAllLazyload(ctx, false)
+	cts, err := s.discovery.FindAllLazyload(ctx, true)
 	if err != nil {
 		logrus.Warnf("Error checking for new containers: %v", err)
 		return
 	}
+	s.discovery.UpdateIndex(ctx, cts)
 
 	runningContainers := make(map[string]*containers.Wrapper)
 	for i, ct := range cts {
+		s.usage.Seen(ct.Name(), usageLabels(ct.Labels), ct.IsRunning())
 		if ct.IsRunning() {
 			runningContainers[ct.ID] = &cts[i]
 		}
 	}
 
-	// Lock
-	s.mux.Lock()
-	defer s.mux.Unlock()
-
 	// check for containers we think are running, but aren't (destroyed, error'd, stop'd via another process, etc)
-	for cid, cts := range s.active {
-		if _, ok := runningContainers[cid]; !ok && !cts.pinned {
+	for cid, cts := range s.snapshot() {
+		_, running := runningContainers[cid]
+		switch state := cts.State(); {
+		case state.Busy():
+			// Being started or stopped; leave it be
+		case !running:
 			logrus.Infof("Discover container had stopped, removing %s", cts.name)
-			delete(s.active, cid)
+			s.remove(cid, cts)
+			if state != StateFailed {
+				s.events.Record(Event{Type: EventExternallyStopped, Container: cts.name})
+			}
 			s.stopDependenciesFor(ctx, cid, cts)
+		case state == StateFailed:
+			logrus.Infof("Failed container %s is running after all", cts.name)
+			if cts.transitionFrom(StateFailed, StateRunning) {
+				s.usage.Started(cts.container, cts.labels)
+			}
 		}
 	}
 
 	// now, look for containers that are running, but aren't in our active inventory
+	s.mux.Lock()
+	defer s.mux.Unlock()
 	for _, ct := range runningContainers {
+		delete(s.wouldStart, ct.ID) // Started after all
 		if _, ok := s.active[ct.ID]; !ok {
 			logrus.Infof("Discovered running container %s", ct.NameID())
-			s.active[ct.ID] = newStateFromContainer(ct)
+			ets := newStateFromContainer(ct)
+			ets.transition(StateRunning)
+			s.active[ct.ID] = ets
+			s.usage.Started(ets.container, ets.labels)
 		}
 	}
 }
 
 func (s *Core) watchForInactivitySync(ctx context.Context) {
-	s.mux.Lock()
-	defer s.mux.Unlock()
+	active := s.snapshot()
+	cids := make([]string, 0, len(active))
+	for cid, cts := range active {
+		if cts.State().Ready() {
+			cids = append(cids, cid)
+		}
+	}
 
-	for cid, cts := range s.active {
-		shouldStop, err := s.checkContainerForInactivity(ctx, cid, cts)
+	stats := collectStats(ctx, s.stats, cids, config.Current().StatsWorkers)
+
+	for cid, result := range stats {
+		cts := active[cid]
+		if result.err != nil {
+			logrus.Warnf("error checking container state for %s: %s", cts.name, result.err)
+			continue
+		}
+
+		shouldStop, err := s.checkContainerForInactivity(ctx, cid, cts, result.stats)
 		if err != nil {
 			logrus.Warnf("error checking container state for %s: %s", cts.name, err)
 		}
-		if shouldStop {
-			s.stopContainerAndDependencies(ctx, cid, cts)
+		// Only if nothing woke it up during the checks
+		if shouldStop && cts.transitionFrom(StateIdle, StateStopping) {
+			idleFor := time.Since(cts.LastActive()).Round(time.Second)
+			if s.stopContainerAndDependencies(ctx, cid, cts) {
+				s.events.Record(Event{Type: EventIdleStopped, Container: cts.name, Duration: idleFor.String()})
+			}
 		}
 	}
 }
 
-func (s *Core) stopContainerAndDependencies(ctx context.Context, cid string, cts *ContainerState) {
-	// First, stop the host container
-	if err := s.client.ContainerStop(ctx, cid, container.StopOptions{}); err != nil {
-		logrus.Errorf("Error stopping container %s: %s", cts.name, err)
-	} else {
-		logrus.Infof("Stopped container %s", cts.name)
-		delete(s.active, cid)
+// Stops a container in the Stopping state, and then any dependencies no longer needed. Returns
+// false if it couldn't be stopped
+func (s *Core) stopContainerAndDependencies(ctx context.Context, cid string, cts *ContainerState) bool {
+	idleFor := time.Since(cts.LastActive()).Round(time.Second)
+	if err := s.dryRun(Event{Type: EventWouldStop, Container: cts.name, Duration: idleFor.String()}); err != nil {
 		s.stopDependenciesFor(ctx, cid, cts)
+		// As if it had been stopped and woken again, so it's reported once per stop delay
+		cts.transition(StateIdle)
+		cts.markActive()
+		return false
+	}
+
+	// First, stop the host container. The hook has its own timeout, so the rest gets a fresh one
+	s.prestop(s.startCtx, cid, cts)
+	ctx, cancel := context.WithTimeout(s.startCtx, config.Current().Timeout)
+	defer cancel()
+	if err := s.client.ContainerStop(ctx, cid, cts.stopOptions); err != nil {
+		logrus.Errorf("Error stopping container %s: %s", cts.name, err)
+		cts.transition(StateIdle)
+		return false
 	}
+
+	logrus.Infof("Stopped container %s", cts.name)
+	s.remove(cid, cts)
+	s.stopDependenciesFor(ctx, cid, cts)
+	return true
 }
 
-func (s *Core) checkContainerForInactivity(ctx context.Context, cid string, ct *ContainerState) (shouldStop bool, retErr error) {
-	if ct.pinned {
-		return false, nil
+func (s *Core) checkContainerForInactivity(ctx context.Context, cid string, ct *ContainerState, stats *container.StatsResponse) (shouldStop bool, retErr error) {
+	if stats.PidsStats.Current == 0 {
+		// Probably stopped. Will let next poll update container
+		return false, errors.New("container not running")
 	}
 
-	statsStream, err := s.client.ContainerStatsOneShot(ctx, cid)
+	interfaces, err := s.idleInterfacesFor(ctx, cid, ct)
 	if err != nil {
 		return false, err
 	}
-	defer func() {
-		if closeErr := statsStream.Body.Close(); closeErr != nil {
-			logrus.Warnf("Error closing stats stream for container %s: %v", cid, closeErr)
+	if !ct.sampleActivity(stats, interfaces, time.Now()) {
+		return false, nil
+	}
+
+	// Idle past its stop delay, but may still be in use
+	if ct.idleConns >= 0 {
+		if conns, err := s.establishedConnections(ctx, cid); err != nil {
+			logrus.Warnf("Unable to count connections of %s: %v", ct.name, err)
+		} else if conns > ct.idleConns {
+			logrus.Infof("Idle container %s has %d connections, not stopping", ct.name, conns)
+			ct.markActive()
+			return false, nil
 		}
-	}()
+	}
+	if s.isBusy(ctx, cid, ct) {
+		logrus.Infof("Idle container %s reports it's busy, not stopping", ct.name)
+		ct.markActive()
+		return false, nil
+	}
+	logrus.Infof("Found idle container %s...", ct.name)
+	return true, nil
+}
 
-	var stats container.StatsResponse
-	if err := json.NewDecoder(statsStream.Body).Decode(&stats); err != nil {
-		return false, err
+// Resolve (once per container) the interfaces whose traffic counts towards activity. nil is all
+func (s *Core) idleInterfacesFor(ctx context.Context, cid string, ct *ContainerState) ([]string, error) {
+	ct.mux.Lock()
+	resolved, ifaces := ct.interfacesResolved, ct.idleInterfaces
+	ct.mux.Unlock()
+	if len(ct.idleNetworks) == 0 || resolved {
+		return ifaces, nil
 	}
 
-	if stats.PidsStats.Current == 0 {
-		// Probably stopped. Will let next poll update container
-		return true, errors.New("container not running")
+	inspect, err := s.client.ContainerInspect(ctx, cid)
+	if err != nil {
+		return nil, err
 	}
 
-	// check for network activity
-	rx, tx := sumNetworkBytes(stats.Networks)
-	if rx > ct.lastRecv || tx > ct.lastSend {
-		ct.lastRecv = rx
-		ct.lastSend = tx
-		ct.lastActivity = time.Now()
-		return false, nil
+	var endpoints map[string]*network.EndpointSettings
+	if inspect.NetworkSettings != nil {
+		endpoints = inspect.NetworkSettings.Networks
 	}
 
-	// No activity, stop?
-	if time.Now().After(ct.lastActivity.Add(ct.stopDelay)) {
-		logrus.Infof("Found idle container %s...", ct.name)
-		return true, nil
+	ifaces, unresolved := interfacesForNetworks(ct.idleNetworks, endpoints)
+
+	ct.mux.Lock()
+	defer ct.mux.Unlock()
+	if len(unresolved) > 0 {
+		// Counting only the other interfaces (or none) could stop it while in use. Retried each poll
+		if !slices.Equal(unresolved, ct.unmappedNetworks) {
+			logrus.Warnf("Unable to map networks %v of %s to interfaces, so all its traffic counts as activity; set the %s driver option, or list interface names instead",
+				unresolved, ct.name, ifnameDriverOpt)
+		}
+		ct.unmappedNetworks = unresolved
+		return nil, nil
 	}
 
-	return false, nil
+	logrus.Debugf("Counting network activity of %s on %v", ct.name, ifaces)
+	ct.unmappedNetworks = nil
+	ct.idleInterfaces, ct.interfacesResolved = ifaces, true
+	return ifaces, nil
 }
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newShutdownCore(t *testing.T) *Core {
+	usage, err := NewUsageTracker("")
+	assert.NoError(t, err)
+
+	core := &Core{usage: usage, active: make(map[string]*ContainerState)}
+	core.startCtx, core.cancelStarts = context.WithCancel(context.Background())
+
+	pollCtx, stopPolling := context.WithCancel(context.Background())
+	core.stopPolling = stopPolling
+	core.polling.Add(1)
+	go core.pollThread(pollCtx, time.Hour)
+	return core
+}
+
+func TestShutdownWaitsForStarts(t *testing.T) {
+	core := newShutdownCore(t)
+
+	finished := false
+	core.starting.Add(1)
+	go func() {
+		defer core.starting.Done()
+		time.Sleep(50 * time.Millisecond)
+		finished = true
+	}()
+
+	assert.NoError(t, core.Shutdown(context.Background()))
+	assert.True(t, finished)
+
+	_, err := core.StartHost("example.com", Requester{})
+	assert.ErrorIs(t, err, ErrShuttingDown)
+}
+
+func TestShutdownCancelsSlowStarts(t *testing.T) {
+	core := newShutdownCore(t)
+
+	var startErr error
+	core.starting.Add(1)
+	go func() {
+		defer core.starting.Done()
+		<-core.startCtx.Done()
+		startErr = core.startCtx.Err()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, core.Shutdown(ctx), context.DeadlineExceeded)
+	assert.ErrorIs(t, startErr, context.Canceled)
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// State is where a managed container is in its lifecycle
+type State string
+
+const (
+	StateStopped      State = "Stopped"      // Not running (and no longer tracked)
+	StateStartingDeps State = "StartingDeps" // Starting the containers it needs
+	StateStarting     State = "Starting"     // Asked docker to start it
+	StateWaitingReady State = "WaitingReady" // Started, waiting for it to be running (and healthy)
+	StateRunning      State = "Running"      // Ready, and was active at the last poll
+	StateIdle         State = "Idle"         // Ready, but wasn't active at the last poll
+	StateStopping     State = "Stopping"     // Asked docker to stop it
+	StateFailed       State = "Failed"       // Failed to start
+)
+
+// Allowed transitions, from -> to
+var stateTransitions = map[State][]State{
+	StateStopped:      {StateStartingDeps, StateRunning},
+	StateStartingDeps: {StateStarting, StateStopping, StateFailed},
+	StateStarting:     {StateWaitingReady, StateStopping, StateStopped, StateFailed}, // Stopped: not started in dry-run mode
+	StateWaitingReady: {StateRunning, StateStopping, StateFailed},
+	StateRunning:      {StateIdle, StateStopping, StateStopped},
+	StateIdle:         {StateRunning, StateStopping, StateStopped},
+	StateStopping:     {StateStopped, StateIdle},
+	StateFailed:       {StateStartingDeps, StateRunning, StateStopping, StateStopped},
+}
+
+func canTransition(from, to State) bool {
+	for _, allowed := range stateTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the container is done starting and can serve requests
+func (s State) Ready() bool {
+	return s == StateRunning || s == StateIdle
+}
+
+// Whether a start or stop is in progress, which owns the container until it's done
+func (s State) Busy() bool {
+	switch s {
+	case StateStartingDeps, StateStarting, StateWaitingReady, StateStopping:
+		return true
+	}
+	return false
+}
+
+// Current lifecycle state
+func (s *ContainerState) State() State {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.state
+}
+
+// How long the container has been in its current state
+func (s *ContainerState) StateAge() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return time.Since(s.stateSince).Round(time.Second).String()
+}
+
+// Moves to state `to`, if allowed from the current state
+func (s *ContainerState) transition(to State) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.transitionLocked(to)
+}
+
+// Moves to state `to` only if currently in state `from`
+func (s *ContainerState) transitionFrom(from, to State) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.state != from {
+		return false
+	}
+	return s.transitionLocked(to)
+}
+
+// Moves to Stopping, returning the state it was in, to go back to if it isn't stopped after all
+func (s *ContainerState) beginStop() (prev State, ok bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	prev = s.state
+	return prev, s.transitionLocked(StateStopping)
+}
+
+// Goes back from Stopping to the state before, when the container wasn't stopped after all (eg. in
+// dry-run mode). Unlike a transition, this can return to any state
+func (s *ContainerState) abortStop(prev State) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.state != StateStopping {
+		return
+	}
+	s.state, s.stateSince = prev, time.Now()
+	logrus.Infof("%s: %s -> %s (not stopped)", s.name, StateStopping, prev)
+}
+
+func (s *ContainerState) transitionLocked(to State) bool {
+	from := s.state
+	if !canTransition(from, to) {
+		logrus.Debugf("Ignoring transition of %s from %s to %s", s.name, from, to)
+		return false
+	}
+
+	now := time.Now()
+	s.state, s.stateSince = to, now
+	if to == StateRunning && from != StateIdle {
+		// Newly ready; the stop delay counts from here
+		s.started, s.lastActivity = now, now
+	}
+
+	if (from == StateRunning && to == StateIdle) || (from == StateIdle && to == StateRunning) {
+		logrus.Debugf("%s: %s -> %s", s.name, from, to)
+	} else {
+		logrus.Infof("%s: %s -> %s", s.name, from, to)
+	}
+	return true
+}
+
+// Resets the idle timer, waking an idle container
+func (s *ContainerState) markActive() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.lastActivity = time.Now()
+	if s.state == StateIdle {
+		s.transitionLocked(StateRunning)
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransition(t *testing.T) {
+	ct := &ContainerState{name: "app", state: StateStopped}
+
+	assert.True(t, ct.transition(StateStartingDeps))
+	assert.False(t, ct.transition(StateRunning), "can't skip starting")
+	assert.True(t, ct.transition(StateStarting))
+	assert.True(t, ct.transition(StateWaitingReady))
+	assert.False(t, ct.State().Ready())
+
+	assert.True(t, ct.transition(StateRunning))
+	assert.True(t, ct.State().Ready())
+	assert.False(t, ct.started.IsZero())
+
+	assert.False(t, ct.transitionFrom(StateIdle, StateStopping), "not idle")
+	assert.True(t, ct.transition(StateIdle))
+	assert.True(t, ct.transitionFrom(StateIdle, StateStopping))
+	assert.True(t, ct.State().Busy())
+
+	assert.True(t, ct.transition(StateStopped))
+	assert.Equal(t, StateStopped, ct.State())
+}
+
+func TestMarkActive(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+	ct := &ContainerState{name: "app", state: StateIdle, lastActivity: old}
+
+	ct.markActive()
+	assert.Equal(t, StateRunning, ct.state)
+	assert.True(t, ct.lastActivity.After(old))
+
+	ct.state = StateWaitingReady
+	ct.markActive()
+	assert.Equal(t, StateWaitingReady, ct.state, "only wakes idle containers")
+}
+
+func TestSampleActivity(t *testing.T) {
+	now := time.Now()
+	ct := &ContainerState{
+		name:              "app",
+		containerSettings: containerSettings{stopDelay: time.Minute, hasStopDelay: true, activity: activityNetwork},
+		state:             StateRunning,
+		lastActivity:      now,
+	}
+	stats := func(rx uint64) *container.StatsResponse {
+		return &container.StatsResponse{Networks: map[string]container.NetworkStats{"eth0": {RxBytes: rx}}}
+	}
+
+	// Traffic keeps it running
+	assert.False(t, ct.sampleActivity(stats(100), nil, now))
+	assert.Equal(t, StateRunning, ct.state)
+
+	// No traffic makes it idle, but within the stop delay
+	now = now.Add(30 * time.Second)
+	assert.False(t, ct.sampleActivity(stats(100), nil, now))
+	assert.Equal(t, StateIdle, ct.state)
+
+	// Past the stop delay
+	now = now.Add(time.Minute)
+	assert.True(t, ct.sampleActivity(stats(100), nil, now))
+
+	// Traffic wakes it
+	now = now.Add(time.Second)
+	assert.False(t, ct.sampleActivity(stats(200), nil, now))
+	assert.Equal(t, StateRunning, ct.state)
+
+	// Not sampled while starting
+	ct.state = StateStarting
+	assert.False(t, ct.sampleActivity(stats(200), nil, now.Add(time.Hour)))
+}
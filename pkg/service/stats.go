@@ -0,0 +1,289 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/sirupsen/logrus"
+)
+
+// statsCollector reads the resource usage of a single container
+type statsCollector interface {
+	Stats(ctx context.Context, cid string) (*container.StatsResponse, error)
+}
+
+type statsResult struct {
+	stats *container.StatsResponse
+	err   error
+}
+
+// collectStats gathers stats for many containers in parallel, with at most `workers` at once
+func collectStats(ctx context.Context, collector statsCollector, cids []string, workers int) map[string]statsResult {
+	if workers < 1 {
+		workers = 1
+	}
+
+	ret := make(map[string]statsResult, len(cids))
+	var mux sync.Mutex
+	var wg sync.WaitGroup
+
+	queue := make(chan string)
+	for range min(workers, len(cids)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for cid := range queue {
+				stats, err := collector.Stats(ctx, cid)
+				mux.Lock()
+				ret[cid] = statsResult{stats, err}
+				mux.Unlock()
+			}
+		}()
+	}
+
+	for _, cid := range cids {
+		queue <- cid
+	}
+	close(queue)
+	wg.Wait()
+
+	return ret
+}
+
+// dockerStats reads stats via the docker API (one-shot, so no PreCPUStats)
+type dockerStats struct {
+	client containers.Host
+}
+
+func (s *dockerStats) Stats(ctx context.Context, cid string) (*container.StatsResponse, error) {
+	statsStream, err := s.client.ContainerStatsOneShot(ctx, cid)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := statsStream.Body.Close(); closeErr != nil {
+			logrus.Warnf("Error closing stats stream for container %s: %v", cid, closeErr)
+		}
+	}()
+
+	var stats container.StatsResponse
+	if err := json.NewDecoder(statsStream.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// procStats reads stats directly from the host's procfs and cgroup v2 filesystem, which needs
+// the host pid namespace. Falls back to another collector for containers it can't read
+type procStats struct {
+	client     containers.Host
+	procRoot   string
+	cgroupRoot string
+	fallback   statsCollector
+
+	mux  sync.Mutex
+	pids map[string]int // cid -> host pid, cached from inspect
+}
+
+func newProcStats(client containers.Host, procRoot, cgroupRoot string, fallback statsCollector) *procStats {
+	return &procStats{
+		client:     client,
+		procRoot:   procRoot,
+		cgroupRoot: cgroupRoot,
+		fallback:   fallback,
+		pids:       make(map[string]int),
+	}
+}
+
+func (s *procStats) Stats(ctx context.Context, cid string) (*container.StatsResponse, error) {
+	stats, err := s.readStats(ctx, cid)
+	if err != nil {
+		logrus.Debugf("Unable to read stats of %s from procfs, falling back: %v", cid, err)
+		s.mux.Lock()
+		delete(s.pids, cid) // may have restarted
+		s.mux.Unlock()
+		return s.fallback.Stats(ctx, cid)
+	}
+	return stats, nil
+}
+
+func (s *procStats) readStats(ctx context.Context, cid string) (*container.StatsResponse, error) {
+	pid, err := s.pid(ctx, cid)
+	if err != nil {
+		return nil, err
+	}
+	pidDir := filepath.Join(s.procRoot, strconv.Itoa(pid))
+
+	networks, err := readNetDev(filepath.Join(pidDir, "net", "dev"))
+	if err != nil {
+		return nil, err
+	}
+
+	cgroupDir, err := s.cgroupDir(pidDir, cid)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := readCgroupCPUUsage(filepath.Join(cgroupDir, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+	systemUsage, onlineCPUs, err := readProcStatCPU(filepath.Join(s.procRoot, "stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	pids := uint64(1)
+	if data, err := os.ReadFile(filepath.Join(cgroupDir, "pids.current")); err == nil { //nolint:gosec
+		pids, _ = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	}
+
+	return &container.StatsResponse{
+		ID:        cid,
+		PidsStats: container.PidsStats{Current: pids},
+		Networks:  networks,
+		CPUStats: container.CPUStats{
+			CPUUsage:    container.CPUUsage{TotalUsage: usage},
+			SystemUsage: systemUsage,
+			OnlineCPUs:  onlineCPUs,
+		},
+	}, nil
+}
+
+func (s *procStats) pid(ctx context.Context, cid string) (int, error) {
+	s.mux.Lock()
+	pid, ok := s.pids[cid]
+	s.mux.Unlock()
+	if ok {
+		return pid, nil
+	}
+
+	inspect, err := s.client.ContainerInspect(ctx, cid)
+	if err != nil {
+		return 0, err
+	}
+	if inspect.State == nil || inspect.State.Pid == 0 {
+		return 0, fmt.Errorf("container %s not running", cid)
+	}
+
+	s.mux.Lock()
+	s.pids[cid] = inspect.State.Pid
+	s.mux.Unlock()
+	return inspect.State.Pid, nil
+}
+
+// Find the container's cgroup directory, from /proc/<pid>/cgroup or else docker's naming conventions
+func (s *procStats) cgroupDir(pidDir, cid string) (string, error) {
+	candidates := make([]string, 0, 3)
+	if data, err := os.ReadFile(filepath.Join(pidDir, "cgroup")); err == nil { //nolint:gosec
+		for _, line := range strings.Split(string(data), "\n") {
+			// cgroup v2 is the unified hierarchy, 0::/path. Paths outside our cgroup namespace contain ..
+			if path, ok := strings.CutPrefix(line, "0::"); ok && !strings.Contains(path, "..") {
+				candidates = append(candidates, filepath.Join(s.cgroupRoot, path))
+			}
+		}
+	}
+	candidates = append(candidates,
+		filepath.Join(s.cgroupRoot, "system.slice", "docker-"+cid+".scope"), // systemd driver
+		filepath.Join(s.cgroupRoot, "docker", cid),                          // cgroupfs driver
+	)
+
+	for _, dir := range candidates {
+		if _, err := os.Stat(filepath.Join(dir, "cpu.stat")); err == nil {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("no cgroup v2 directory found for %s", cid)
+}
+
+// readNetDev reads per-interface byte counters from /proc/<pid>/net/dev, excluding loopback
+func readNetDev(path string) (map[string]container.NetworkStats, error) {
+	f, err := os.Open(path) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ret := make(map[string]container.NetworkStats)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		iface, counters, ok := strings.Cut(scanner.Text(), ":")
+		iface = strings.TrimSpace(iface)
+		if !ok || iface == "lo" {
+			continue // headers and loopback
+		}
+		// rx: bytes packets errs drop fifo frame compressed multicast, tx: bytes ...
+		fields := strings.Fields(counters)
+		if len(fields) < 9 {
+			continue
+		}
+		rx, rxErr := strconv.ParseUint(fields[0], 10, 64)
+		tx, txErr := strconv.ParseUint(fields[8], 10, 64)
+		if rxErr != nil || txErr != nil {
+			continue
+		}
+		ret[iface] = container.NetworkStats{RxBytes: rx, TxBytes: tx}
+	}
+	return ret, scanner.Err()
+}
+
+// readCgroupCPUUsage reads total cpu time (ns) from a cgroup v2 cpu.stat
+func readCgroupCPUUsage(path string) (uint64, error) {
+	data, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if val, ok := strings.CutPrefix(line, "usage_usec "); ok {
+			usec, err := strconv.ParseUint(strings.TrimSpace(val), 10, 64)
+			return usec * 1000, err
+		}
+	}
+	return 0, fmt.Errorf("no usage_usec in %s", path)
+}
+
+// Clock ticks per second of /proc/stat; fixed on linux
+const userHZ = 100
+
+// readProcStatCPU reads total host cpu time (ns) and number of cpus from /proc/stat, the same
+// way docker computes system_cpu_usage: user through softirq, leaving out steal and guest time
+func readProcStatCPU(path string) (systemUsage uint64, onlineCPUs uint32, err error) {
+	data, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return 0, 0, err
+	}
+
+	found := false
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		if fields[0] != "cpu" {
+			onlineCPUs++
+			continue
+		}
+		// cpu user nice system idle iowait irq softirq steal ...
+		found = true
+		for _, f := range fields[1:min(len(fields), 8)] {
+			ticks, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				return 0, 0, err
+			}
+			systemUsage += ticks
+		}
+	}
+	if !found {
+		return 0, 0, fmt.Errorf("no cpu line in %s", path)
+	}
+
+	return systemUsage * (1e9 / userHZ), onlineCPUs, nil
+}
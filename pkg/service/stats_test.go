@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStats struct {
+	inFlight, maxInFlight atomic.Int32
+}
+
+func (s *fakeStats) Stats(ctx context.Context, cid string) (*container.StatsResponse, error) {
+	n := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		maxN := s.maxInFlight.Load()
+		if n <= maxN || s.maxInFlight.CompareAndSwap(maxN, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if cid == "bad" {
+		return nil, errors.New("no stats")
+	}
+	return &container.StatsResponse{ID: cid}, nil
+}
+
+func TestCollectStats(t *testing.T) {
+	collector := &fakeStats{}
+	results := collectStats(context.Background(), collector, []string{"a", "b", "c", "d", "bad"}, 2)
+
+	assert.Len(t, results, 5)
+	assert.Equal(t, "c", results["c"].stats.ID)
+	assert.Error(t, results["bad"].err)
+	assert.LessOrEqual(t, collector.maxInFlight.Load(), int32(2))
+
+	assert.Empty(t, collectStats(context.Background(), collector, nil, 4))
+}
+
+func TestReadNetDev(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dev")
+	assert.NoError(t, os.WriteFile(path, []byte(`Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:     500       5    0    0    0     0          0         0      500       5    0    0    0     0       0          0
+  eth0: 1234567    1000    0    0    0     0          0         0   765432     900    0    0    0     0       0          0
+  eth1:100 1 0 0 0 0 0 0 200 2 0 0 0 0 0 0
+`), 0o600))
+
+	networks, err := readNetDev(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]container.NetworkStats{
+		"eth0": {RxBytes: 1234567, TxBytes: 765432},
+		"eth1": {RxBytes: 100, TxBytes: 200},
+	}, networks)
+}
+
+func TestReadCgroupAndProcStat(t *testing.T) {
+	dir := t.TempDir()
+
+	cpuStat := filepath.Join(dir, "cpu.stat")
+	assert.NoError(t, os.WriteFile(cpuStat, []byte("usage_usec 2500\nuser_usec 2000\nsystem_usec 500\n"), 0o600))
+	usage, err := readCgroupCPUUsage(cpuStat)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2500000), usage)
+
+	procStat := filepath.Join(dir, "stat")
+	assert.NoError(t, os.WriteFile(procStat, []byte(`cpu  100 0 50 800 50 0 0 25 0 0
+cpu0 50 0 25 400 25 0 0 0 0 0
+cpu1 50 0 25 400 25 0 0 0 0 0
+intr 12345
+`), 0o600))
+	system, cpus, err := readProcStatCPU(procStat)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1000*1e9/userHZ), system)
+	assert.Equal(t, uint32(2), cpus)
+}
+
+func TestProcStatsCgroupDir(t *testing.T) {
+	root := t.TempDir()
+	pidDir := filepath.Join(root, "proc", "42")
+	cgroupRoot := filepath.Join(root, "cgroup")
+	assert.NoError(t, os.MkdirAll(pidDir, 0o700))
+
+	stats := newProcStats(nil, filepath.Join(root, "proc"), cgroupRoot, nil)
+
+	// From /proc/<pid>/cgroup
+	scope := filepath.Join(cgroupRoot, "custom.slice", "app.scope")
+	assert.NoError(t, os.MkdirAll(scope, 0o700))
+	assert.NoError(t, os.WriteFile(filepath.Join(scope, "cpu.stat"), nil, 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(pidDir, "cgroup"), []byte("0::/custom.slice/app.scope\n"), 0o600))
+	dir, err := stats.cgroupDir(pidDir, "abc")
+	assert.NoError(t, err)
+	assert.Equal(t, scope, dir)
+
+	// By convention, if outside our cgroup namespace
+	assert.NoError(t, os.WriteFile(filepath.Join(pidDir, "cgroup"), []byte("0::/../../docker-abc.scope\n"), 0o600))
+	systemd := filepath.Join(cgroupRoot, "system.slice", "docker-abc.scope")
+	assert.NoError(t, os.MkdirAll(systemd, 0o700))
+	assert.NoError(t, os.WriteFile(filepath.Join(systemd, "cpu.stat"), nil, 0o600))
+	dir, err = stats.cgroupDir(pidDir, "abc")
+	assert.NoError(t, err)
+	assert.Equal(t, systemd, dir)
+
+	_, err = stats.cgroupDir(pidDir, "other")
+	assert.Error(t, err)
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Group used for containers without the label being grouped by
+const usageNoGroup = "(none)"
+
+// UsageStats is the accumulated usage of one container, by name so it survives re-creation
+type UsageStats struct {
+	Container     string            `json:"container"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Uptime        time.Duration     `json:"uptime"`        // Up to Since
+	Stopped       time.Duration     `json:"stopped"`       // Up to Since
+	ColdStarts    int               `json:"coldStarts"`    // Starts on request
+	ColdStartTime time.Duration     `json:"coldStartTime"` // Total time cold starts took to be ready
+	Running       bool              `json:"running"`
+	Since         time.Time         `json:"since"` // When Running last changed
+}
+
+// Totals up to now, including the time since the last change
+func (s *UsageStats) totals(now time.Time) (uptime, stopped time.Duration) {
+	uptime, stopped = s.Uptime, s.Stopped
+	if s.Running {
+		uptime += now.Sub(s.Since)
+	} else {
+		stopped += now.Sub(s.Since)
+	}
+	return
+}
+
+// Moves the time since the last change into uptime or stopped, and sets running
+func (s *UsageStats) setRunning(running bool, now time.Time) {
+	s.Uptime, s.Stopped = s.totals(now)
+	s.Running, s.Since = running, now
+}
+
+// UsageReport is the usage of a group of containers (or a single one)
+type UsageReport struct {
+	Group               string  `json:"group"`
+	Containers          int     `json:"containers"`
+	UptimeSeconds       float64 `json:"uptimeSeconds"`
+	StoppedSeconds      float64 `json:"stoppedSeconds"`
+	SavedPercent        float64 `json:"savedPercent"` // Share of the time stopped, ie. the resources saved
+	ColdStarts          int     `json:"coldStarts"`
+	AvgColdStartSeconds float64 `json:"avgColdStartSeconds"`
+}
+
+func (s UsageReport) Uptime() string {
+	return (time.Duration(s.UptimeSeconds) * time.Second).String()
+}
+
+func (s UsageReport) Stopped() string {
+	return (time.Duration(s.StoppedSeconds) * time.Second).String()
+}
+
+func (s UsageReport) AvgColdStart() string {
+	return (time.Duration(s.AvgColdStartSeconds*1000) * time.Millisecond).String()
+}
+
+// UsageTracker accounts uptime, stopped time and cold starts per container, optionally persisted
+// to a JSON file
+type UsageTracker struct {
+	mux   sync.Mutex
+	path  string
+	stats map[string]*UsageStats // container name -> stats
+	now   func() time.Time
+}
+
+// NewUsageTracker loads previous usage from path, if set and it exists
+func NewUsageTracker(path string) (*UsageTracker, error) {
+	ret := &UsageTracker{
+		path:  path,
+		stats: make(map[string]*UsageStats),
+		now:   time.Now,
+	}
+	if path == "" {
+		return ret, nil
+	}
+
+	data, err := os.ReadFile(path) //nolint:gosec
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return ret, nil
+	case err != nil:
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &ret.stats); err != nil {
+		return nil, fmt.Errorf("reading usage from %s: %w", path, err)
+	}
+	for _, stats := range ret.stats {
+		stats.Labels = usageLabels(stats.Labels) // Saved before only these were kept
+	}
+	return ret, nil
+}
+
+// Seen starts accounting for a container (as stopped) if it isn't already, and notices if it
+// stopped unseen (eg. while the lazyloader wasn't running)
+func (s *UsageTracker) Seen(name string, labels map[string]string, running bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	changed := s.get(name, labels)
+	if stats := s.stats[name]; stats.Running && !running {
+		stats.setRunning(false, s.now())
+		changed = true
+	}
+	if changed {
+		s.saveLocked()
+	}
+}
+
+// Started records a container found running (not started on request)
+func (s *UsageTracker) Started(name string, labels map[string]string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.get(name, labels)
+	s.stats[name].setRunning(true, s.now())
+	s.saveLocked()
+}
+
+// ColdStarted records a container started on request, and how long it took to be ready
+func (s *UsageTracker) ColdStarted(name string, labels map[string]string, took time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.get(name, labels)
+	stats := s.stats[name]
+	stats.setRunning(true, s.now())
+	stats.ColdStarts++
+	stats.ColdStartTime += took
+	s.saveLocked()
+}
+
+// Stopped records a container stopping
+func (s *UsageTracker) Stopped(name string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if stats, ok := s.stats[name]; ok && stats.Running {
+		stats.setRunning(false, s.now())
+		s.saveLocked()
+	}
+}
+
+// Get the stats of a container, creating (and returning true) if new. Labels are kept up to date
+func (s *UsageTracker) get(name string, labels map[string]string) bool {
+	if stats, ok := s.stats[name]; ok {
+		stats.Labels = labels
+		return false
+	}
+	s.stats[name] = &UsageStats{
+		Container: name,
+		Labels:    labels,
+		Since:     s.now(),
+	}
+	return true
+}
+
+// Report aggregates usage by container, or by the value of a label if groupBy is set
+func (s *UsageTracker) Report(groupBy string) []UsageReport {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	now := s.now()
+	groups := make(map[string]*UsageReport)
+	coldStartTime := make(map[string]time.Duration)
+	for name, stats := range s.stats {
+		group := name
+		if groupBy != "" {
+			if group = stats.Labels[groupBy]; group == "" {
+				group = usageNoGroup
+			}
+		}
+
+		report, ok := groups[group]
+		if !ok {
+			report = &UsageReport{Group: group}
+			groups[group] = report
+		}
+		uptime, stopped := stats.totals(now)
+		report.Containers++
+		report.UptimeSeconds += uptime.Seconds()
+		report.StoppedSeconds += stopped.Seconds()
+		report.ColdStarts += stats.ColdStarts
+		coldStartTime[group] += stats.ColdStartTime
+	}
+
+	ret := make([]UsageReport, 0, len(groups))
+	for group, report := range groups {
+		if report.ColdStarts > 0 {
+			report.AvgColdStartSeconds = coldStartTime[group].Seconds() / float64(report.ColdStarts)
+		}
+		if total := report.UptimeSeconds + report.StoppedSeconds; total > 0 {
+			report.SavedPercent = report.StoppedSeconds / total * 100
+		}
+		ret = append(ret, *report)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Group < ret[j].Group
+	})
+	return ret
+}
+
+// Save writes the usage to its file, if set
+func (s *UsageTracker) Save() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.save()
+}
+
+// Saves, only logging errors; usage is saved again on the next change
+func (s *UsageTracker) saveLocked() {
+	if err := s.save(); err != nil {
+		logrus.Warnf("Unable to save usage: %v", err)
+	}
+}
+
+func (s *UsageTracker) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.stats)
+	if err != nil {
+		return err
+	}
+
+	// Write then rename, so a crash never leaves a partial file
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil { //nolint:gosec
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// WriteUsageCSV writes a usage report as CSV, with a header
+func WriteUsageCSV(w io.Writer, report []UsageReport) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"group", "containers", "uptime_seconds", "stopped_seconds", "saved_percent", "cold_starts", "avg_cold_start_seconds"})
+	for _, row := range report {
+		out.Write([]string{
+			row.Group,
+			strconv.Itoa(row.Containers),
+			strconv.FormatFloat(row.UptimeSeconds, 'f', 0, 64),
+			strconv.FormatFloat(row.StoppedSeconds, 'f', 0, 64),
+			strconv.FormatFloat(row.SavedPercent, 'f', 1, 64),
+			strconv.Itoa(row.ColdStarts),
+			strconv.FormatFloat(row.AvgColdStartSeconds, 'f', 3, 64),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
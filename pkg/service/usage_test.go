@@ -0,0 +1,66 @@
+package service
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageTracker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	usage, err := NewUsageTracker(path)
+	assert.NoError(t, err)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	usage.now = func() time.Time { return now }
+
+	usage.Seen("wiki", map[string]string{"com.docker.compose.project": "docs"}, false)
+	usage.Seen("blog", map[string]string{"com.docker.compose.project": "docs"}, false)
+	usage.Seen("ci", nil, false)
+
+	now = now.Add(time.Hour)
+	usage.ColdStarted("wiki", map[string]string{"com.docker.compose.project": "docs"}, 4*time.Second)
+	now = now.Add(30 * time.Minute)
+	usage.Stopped("wiki")
+	now = now.Add(30 * time.Minute)
+	usage.ColdStarted("wiki", map[string]string{"com.docker.compose.project": "docs"}, 2*time.Second)
+	now = now.Add(time.Hour)
+
+	report := usage.Report("")
+	assert.Len(t, report, 3)
+	wiki := report[2]
+	assert.Equal(t, "wiki", wiki.Group)
+	assert.Equal(t, 2, wiki.ColdStarts)
+	assert.InDelta(t, 3.0, wiki.AvgColdStartSeconds, 0.001)
+	assert.InDelta(t, (90 * time.Minute).Seconds(), wiki.UptimeSeconds, 0.001)
+	assert.InDelta(t, (90 * time.Minute).Seconds(), wiki.StoppedSeconds, 0.001)
+	assert.InDelta(t, 50.0, wiki.SavedPercent, 0.001)
+
+	byProject := usage.Report("com.docker.compose.project")
+	assert.Equal(t, []string{"(none)", "docs"}, []string{byProject[0].Group, byProject[1].Group})
+	assert.Equal(t, 2, byProject[1].Containers)
+	assert.InDelta(t, (3*time.Hour + 90*time.Minute).Seconds(), byProject[1].StoppedSeconds, 0.001)
+
+	// Persisted, and a container that stopped unseen is noticed
+	reloaded, err := NewUsageTracker(path)
+	assert.NoError(t, err)
+	reloaded.now = func() time.Time { return now }
+	assert.Equal(t, report, reloaded.Report(""))
+	assert.Equal(t, byProject, reloaded.Report("com.docker.compose.project"))
+
+	reloaded.Seen("wiki", nil, false)
+	now = now.Add(time.Hour)
+	assert.InDelta(t, (150 * time.Minute).Seconds(), reloaded.Report("")[2].StoppedSeconds, 0.001)
+}
+
+func TestWriteUsageCSV(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, WriteUsageCSV(&buf, []UsageReport{
+		{Group: "docs", Containers: 2, UptimeSeconds: 3600, StoppedSeconds: 10800, SavedPercent: 75, ColdStarts: 3, AvgColdStartSeconds: 2.5},
+	}))
+	assert.Equal(t, "group,containers,uptime_seconds,stopped_seconds,saved_percent,cold_starts,avg_cold_start_seconds\n"+
+		"docs,2,3600,10800,75.0,3,2.500\n", buf.String())
+}
@@ -1,13 +1,115 @@
 package service
 
 import (
+	"fmt"
+	"slices"
+	"strings"
+	"traefik-lazyload/pkg/config"
+	"traefik-lazyload/pkg/containers"
+
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
 )
 
-func sumNetworkBytes(networks map[string]container.NetworkStats) (recv int64, send int64) {
-	for _, ns := range networks {
+// Driver option (docker 28+) that sets the interface name of a network endpoint
+const ifnameDriverOpt = "com.docker.network.endpoint.ifname"
+
+// Compose labels kept for usage accounting, to group by project or service
+var composeUsageLabels = []string{"com.docker.compose.project", "com.docker.compose.service"}
+
+// Labels kept for usage accounting (and persisted): the lazyloader's own (without secrets), and
+// compose's project and service. Others may hold secrets, eg. basicauth hashes of traefik labels
+func usageLabels(labels map[string]string) map[string]string {
+	prefix := config.Current().LabelPrefix
+	ret := make(map[string]string)
+	for k, v := range labels {
+		sublabel, isConfig := strings.CutPrefix(k, prefix+".")
+		switch {
+		case k == prefix, slices.Contains(composeUsageLabels, k):
+		case isConfig && !containers.IsSecretSublabel(sublabel):
+		default:
+			continue
+		}
+		ret[k] = v
+	}
+	return ret
+}
+
+// Sum network bytes over the given interfaces (or all of them, if nil)
+func sumNetworkBytes(networks map[string]container.NetworkStats, interfaces []string) (recv int64, send int64) {
+	for iface, ns := range networks {
+		if interfaces != nil && !slices.Contains(interfaces, iface) {
+			continue
+		}
 		recv += int64(ns.RxBytes) //nolint:gosec
 		send += int64(ns.TxBytes) //nolint:gosec
 	}
 	return
 }
+
+// interfacesForNetworks maps network names to the container's interface names. Names that aren't
+// networks the container is attached to are taken to be interface names already (eg. eth0)
+func interfacesForNetworks(names []string, endpoints map[string]*network.EndpointSettings) (ifaces, unresolved []string) {
+	for _, name := range names {
+		ep, isNetwork := endpoints[name]
+		switch {
+		case !isNetwork:
+			ifaces = append(ifaces, name)
+		case ep != nil && ep.DriverOpts[ifnameDriverOpt] != "":
+			ifaces = append(ifaces, ep.DriverOpts[ifnameDriverOpt])
+		case len(endpoints) == 1:
+			ifaces = append(ifaces, "eth0")
+		default:
+			// Interface order of multiple networks isn't exposed by docker
+			unresolved = append(unresolved, name)
+		}
+	}
+	return
+}
+
+// cpuPercent computes CPU usage (as a percentage of a single core, so can exceed 100%)
+// between two samples of container and host CPU time
+func cpuPercent(stats *container.CPUStats, prevTotal, prevSystem uint64) float64 {
+	if stats.CPUUsage.TotalUsage < prevTotal || stats.SystemUsage <= prevSystem {
+		return 0
+	}
+	cpuDelta := float64(stats.CPUUsage.TotalUsage - prevTotal)
+	systemDelta := float64(stats.SystemUsage - prevSystem)
+
+	onlineCPUs := float64(stats.OnlineCPUs)
+	if onlineCPUs == 0 {
+		onlineCPUs = float64(len(stats.CPUUsage.PercpuUsage))
+	}
+
+	return cpuDelta / systemDelta * onlineCPUs * 100
+}
+
+// isActive combines the activity signals according to the activity setting
+func isActive(activity string, netActive, cpuActive bool) bool {
+	switch activity {
+	case activityCPU:
+		return cpuActive
+	case activityAny:
+		return netActive || cpuActive
+	case activityAll:
+		return netActive && cpuActive
+	case activityRequests:
+		return false
+	default:
+		return netActive
+	}
+}
+
+// formatByteRate formats bytes/sec for humans, eg. 1.5 KiB/s
+func formatByteRate(rate float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	i := 0
+	for rate >= 1024 && i < len(units)-1 {
+		rate /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s/s", rate, units[i])
+	}
+	return fmt.Sprintf("%.1f %s/s", rate, units[i])
+}
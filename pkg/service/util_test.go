@@ -0,0 +1,96 @@
+package service
+
+import (
+	"testing"
+	"traefik-lazyload/pkg/config"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCPUPercent(t *testing.T) {
+	stats := &container.CPUStats{
+		CPUUsage:    container.CPUUsage{TotalUsage: 1500},
+		SystemUsage: 20000,
+		OnlineCPUs:  4,
+	}
+	assert.InDelta(t, 20.0, cpuPercent(stats, 1000, 10000), 0.001)
+
+	// Falls back to per-cpu count
+	stats.OnlineCPUs = 0
+	stats.CPUUsage.PercpuUsage = []uint64{1, 2}
+	assert.InDelta(t, 10.0, cpuPercent(stats, 1000, 10000), 0.001)
+
+	// Counters reset (eg. container restarted)
+	assert.Equal(t, 0.0, cpuPercent(stats, 2000, 10000))
+	assert.Equal(t, 0.0, cpuPercent(stats, 1000, 20000))
+}
+
+func TestIsActive(t *testing.T) {
+	assert.True(t, isActive(activityNetwork, true, false))
+	assert.False(t, isActive(activityNetwork, false, true))
+	assert.True(t, isActive(activityCPU, false, true))
+	assert.False(t, isActive(activityCPU, true, false))
+	assert.True(t, isActive(activityAny, false, true))
+	assert.False(t, isActive(activityAny, false, false))
+	assert.True(t, isActive(activityAll, true, true))
+	assert.False(t, isActive(activityAll, true, false))
+	assert.False(t, isActive(activityRequests, true, true))
+}
+
+func TestFormatByteRate(t *testing.T) {
+	assert.Equal(t, "0 B/s", formatByteRate(0))
+	assert.Equal(t, "512 B/s", formatByteRate(512))
+	assert.Equal(t, "1.5 KiB/s", formatByteRate(1536))
+	assert.Equal(t, "2.0 MiB/s", formatByteRate(2*1024*1024))
+}
+
+func TestSumNetworkBytes(t *testing.T) {
+	networks := map[string]container.NetworkStats{
+		"eth0": {RxBytes: 100, TxBytes: 10},
+		"eth1": {RxBytes: 200, TxBytes: 20},
+	}
+
+	rx, tx := sumNetworkBytes(networks, nil)
+	assert.Equal(t, int64(300), rx)
+	assert.Equal(t, int64(30), tx)
+
+	rx, tx = sumNetworkBytes(networks, []string{"eth1"})
+	assert.Equal(t, int64(200), rx)
+	assert.Equal(t, int64(20), tx)
+}
+
+func TestInterfacesForNetworks(t *testing.T) {
+	single := map[string]*network.EndpointSettings{
+		"proxy": {},
+	}
+	ifaces, unresolved := interfacesForNetworks([]string{"proxy"}, single)
+	assert.Equal(t, []string{"eth0"}, ifaces)
+	assert.Empty(t, unresolved)
+
+	multi := map[string]*network.EndpointSettings{
+		"proxy":   {DriverOpts: map[string]string{ifnameDriverOpt: "front0"}},
+		"backend": {},
+	}
+	ifaces, unresolved = interfacesForNetworks([]string{"proxy", "backend", "eth3"}, multi)
+	assert.Equal(t, []string{"front0", "eth3"}, ifaces)
+	assert.Equal(t, []string{"backend"}, unresolved)
+}
+
+func TestUsageLabels(t *testing.T) {
+	setConfig(t, func(cfg *config.ConfigModel) { cfg.LabelPrefix = "lazyloader" })
+
+	assert.Equal(t, map[string]string{
+		"lazyloader":                 "true",
+		"lazyloader.stopdelay":       "5m",
+		"com.docker.compose.project": "wiki",
+	}, usageLabels(map[string]string{
+		"lazyloader":                                    "true",
+		"lazyloader.stopdelay":                          "5m",
+		"lazyloader.heartbeattoken":                     "secret",
+		"com.docker.compose.project":                    "wiki",
+		"traefik.http.middlewares.auth.basicauth.users": "admin:$apr1$hash",
+		"team": "docs",
+	}))
+}
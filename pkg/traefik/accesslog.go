@@ -0,0 +1,187 @@
+package traefik
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AccessLogEntry is the subset of traefik's JSON access log we care about
+type AccessLogEntry struct {
+	ClientHost  string `json:"ClientHost"`
+	RequestHost string `json:"RequestHost"`
+	RouterName  string `json:"RouterName"`
+	ServiceName string `json:"ServiceName"`
+	UserAgent   string `json:"request_User-Agent"` // Only present if traefik is configured to keep the header
+}
+
+// AccessLogFilter ignores requests from certain user agents or client IPs (eg. uptime monitors)
+type AccessLogFilter struct {
+	userAgents []string
+	nets       []*net.IPNet
+}
+
+// NewAccessLogFilter creates a filter from user agent substrings and IPs or CIDRs
+func NewAccessLogFilter(userAgents, ips []string) (*AccessLogFilter, error) {
+	ret := &AccessLogFilter{}
+	for _, ua := range userAgents {
+		if ua = strings.TrimSpace(ua); ua != "" {
+			ret.userAgents = append(ret.userAgents, strings.ToLower(ua))
+		}
+	}
+	for _, ip := range ips {
+		ip = strings.TrimSpace(ip)
+		if ip == "" {
+			continue
+		}
+		if !strings.Contains(ip, "/") {
+			if strings.Contains(ip, ":") {
+				ip += "/128"
+			} else {
+				ip += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(ip)
+		if err != nil {
+			return nil, err
+		}
+		ret.nets = append(ret.nets, ipNet)
+	}
+	return ret, nil
+}
+
+// Ignore returns true if the entry should not count as activity
+func (s *AccessLogFilter) Ignore(entry *AccessLogEntry) bool {
+	if entry.UserAgent != "" {
+		ua := strings.ToLower(entry.UserAgent)
+		for _, ignored := range s.userAgents {
+			if strings.Contains(ua, ignored) {
+				return true
+			}
+		}
+	}
+	if ip := net.ParseIP(entry.ClientHost); ip != nil {
+		for _, ipNet := range s.nets {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AccessLogTailer follows traefik's JSON access log, handling rotation and truncation
+type AccessLogTailer struct {
+	path         string
+	pollInterval time.Duration
+}
+
+func NewAccessLogTailer(path string) *AccessLogTailer {
+	return &AccessLogTailer{
+		path:         path,
+		pollInterval: time.Second,
+	}
+}
+
+// Tail the log from its current end, calling handle for each entry. Blocks until ctx is cancelled
+func (s *AccessLogTailer) Tail(ctx context.Context, handle func(entry *AccessLogEntry)) {
+	var f *os.File
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	var reader *bufio.Reader
+	var pending string // partial line, not yet terminated
+	fromStart := false // only the initial file is read from its end
+
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if f == nil {
+			var err error
+			if f, err = s.open(fromStart); err != nil {
+				logrus.Debugf("Unable to open access log %s: %v", s.path, err)
+			} else {
+				reader = bufio.NewReader(f)
+				pending = ""
+			}
+			fromStart = true
+		}
+
+		if f != nil {
+			pending = s.readLines(reader, pending, handle)
+			if s.rotated(f) {
+				logrus.Debugf("Access log %s rotated, reopening", s.path)
+				f.Close()
+				f = nil
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *AccessLogTailer) open(fromStart bool) (*os.File, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if !fromStart {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Read all complete lines available, returning any trailing partial line
+func (s *AccessLogTailer) readLines(reader *bufio.Reader, pending string, handle func(entry *AccessLogEntry)) string {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return pending + line
+		}
+		line = pending + line
+		pending = ""
+
+		var entry AccessLogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			logrus.Debugf("Ignoring unparseable access log line: %v", err)
+			continue
+		}
+		handle(&entry)
+	}
+}
+
+// true if the file at path was replaced or truncated since it was opened
+func (s *AccessLogTailer) rotated(f *os.File) bool {
+	current, err := os.Stat(s.path)
+	if err != nil {
+		return false // Not (re)created yet; keep reading the old one
+	}
+	opened, err := f.Stat()
+	if err != nil {
+		return true
+	}
+	if !os.SameFile(current, opened) {
+		return true
+	}
+	pos, err := f.Seek(0, io.SeekCurrent)
+	return err == nil && current.Size() < pos
+}
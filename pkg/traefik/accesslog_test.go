@@ -0,0 +1,74 @@
+package traefik
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLogFilter(t *testing.T) {
+	filter, err := NewAccessLogFilter([]string{"UptimeRobot", ""}, []string{"10.0.0.0/8", "192.168.1.5", "::1"})
+	assert.NoError(t, err)
+
+	assert.True(t, filter.Ignore(&AccessLogEntry{UserAgent: "Mozilla/5.0+(compatible; UptimeRobot/2.0)"}))
+	assert.True(t, filter.Ignore(&AccessLogEntry{ClientHost: "10.1.2.3"}))
+	assert.True(t, filter.Ignore(&AccessLogEntry{ClientHost: "192.168.1.5"}))
+	assert.True(t, filter.Ignore(&AccessLogEntry{ClientHost: "::1"}))
+	assert.False(t, filter.Ignore(&AccessLogEntry{ClientHost: "192.168.1.6", UserAgent: "curl/8.0"}))
+
+	_, err = NewAccessLogFilter(nil, []string{"not-an-ip"})
+	assert.Error(t, err)
+}
+
+func TestAccessLogTailerRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"RequestHost": "old.example.com"}`+"\n"), 0o600))
+
+	var mux sync.Mutex
+	var hosts []string
+	tailer := NewAccessLogTailer(path)
+	tailer.pollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tailer.Tail(ctx, func(entry *AccessLogEntry) {
+		mux.Lock()
+		hosts = append(hosts, entry.RequestHost)
+		mux.Unlock()
+	})
+	seen := func() []string {
+		mux.Lock()
+		defer mux.Unlock()
+		return append([]string(nil), hosts...)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	appendLine := func(line string) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o600)
+		assert.NoError(t, err)
+		f.WriteString(line)
+		f.Close()
+	}
+
+	// Existing lines are skipped; partial lines wait for their newline
+	appendLine(`{"RequestHost": "a.example.com", "RouterName": "a@docker"}` + "\n" + `not json` + "\n" + `{"RequestHost": `)
+	assert.Eventually(t, func() bool { return len(seen()) == 1 }, time.Second, 10*time.Millisecond)
+	appendLine(`"b.example.com"}` + "\n")
+	assert.Eventually(t, func() bool { return len(seen()) == 2 }, time.Second, 10*time.Millisecond)
+
+	// Rotate
+	assert.NoError(t, os.Rename(path, path+".1"))
+	appendLine(`{"RequestHost": "c.example.com"}` + "\n")
+	assert.Eventually(t, func() bool { return len(seen()) == 3 }, time.Second, 10*time.Millisecond)
+
+	// Truncate (detected by the file shrinking)
+	assert.NoError(t, os.WriteFile(path, []byte(`{"RequestHost": "d.io"}`+"\n"), 0o600))
+	assert.Eventually(t, func() bool { return len(seen()) == 4 }, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, []string{"a.example.com", "b.example.com", "c.example.com", "d.io"}, seen())
+}
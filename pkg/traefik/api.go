@@ -0,0 +1,168 @@
+package traefik
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"traefik-lazyload/pkg/containers"
+)
+
+// APIClient reads the http routers and services from traefik's API
+type APIClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+type Router struct {
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+	Rule     string `json:"rule"`
+	Service  string `json:"service"`
+	Status   string `json:"status"`
+}
+
+type Service struct {
+	Name         string        `json:"name"`
+	Provider     string        `json:"provider"`
+	LoadBalancer *LoadBalancer `json:"loadBalancer,omitempty"`
+}
+
+type LoadBalancer struct {
+	Servers []Server `json:"servers" yaml:"servers" toml:"servers"`
+}
+
+type Server struct {
+	URL string `json:"url" yaml:"url" toml:"url"`
+}
+
+func NewAPIClient(baseURL string, client *http.Client) *APIClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &APIClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  client,
+	}
+}
+
+func (s *APIClient) Routers(ctx context.Context) ([]Router, error) {
+	var ret []Router
+	err := s.getAll(ctx, "/api/http/routers", func(dec *json.Decoder) error {
+		var page []Router
+		if err := dec.Decode(&page); err != nil {
+			return err
+		}
+		ret = append(ret, page...)
+		return nil
+	})
+	return ret, err
+}
+
+func (s *APIClient) Services(ctx context.Context) ([]Service, error) {
+	var ret []Service
+	err := s.getAll(ctx, "/api/http/services", func(dec *json.Decoder) error {
+		var page []Service
+		if err := dec.Decode(&page); err != nil {
+			return err
+		}
+		ret = append(ret, page...)
+		return nil
+	})
+	return ret, err
+}
+
+// Routes implements containers.RouteSource, joining each router to its service
+func (s *APIClient) Routes(ctx context.Context) ([]containers.Route, error) {
+	routers, err := s.Routers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	services, err := s.Services(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return joinRoutes(routers, services), nil
+}
+
+// getAll requests every page of a paginated traefik API endpoint. Traefik
+// signals the last page by pointing X-Next-Page back at the first one
+func (s *APIClient) getAll(ctx context.Context, path string, decodePage func(dec *json.Decoder) error) error {
+	for page := 1; ; {
+		next, err := s.get(ctx, path, page, decodePage)
+		if err != nil {
+			return err
+		}
+		if nextPage, err := strconv.Atoi(next); err != nil || nextPage <= page {
+			return nil
+		} else {
+			page = nextPage
+		}
+	}
+}
+
+func (s *APIClient) get(ctx context.Context, path string, page int, decodePage func(dec *json.Decoder) error) (nextPage string, err error) {
+	query := url.Values{}
+	query.Set("page", strconv.Itoa(page))
+	query.Set("per_page", "100")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+path+"?"+query.Encode(), http.NoBody)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("traefik api %s: unexpected status %s", path, resp.Status)
+	}
+	if err := decodePage(json.NewDecoder(resp.Body)); err != nil {
+		return "", fmt.Errorf("traefik api %s: %w", path, err)
+	}
+
+	return resp.Header.Get("X-Next-Page"), nil
+}
+
+// joinRoutes joins each enabled router to its service, producing routes to resolve against containers
+func joinRoutes(routers []Router, services []Service) []containers.Route {
+	servicesByName := make(map[string]*Service, len(services))
+	for i := range services {
+		servicesByName[services[i].Name] = &services[i]
+	}
+
+	ret := make([]containers.Route, 0, len(routers))
+	for _, router := range routers {
+		if router.Status != "" && router.Status != "enabled" {
+			continue
+		}
+
+		// Services without an explicit provider belong to the router's provider
+		serviceName := router.Service
+		if !strings.Contains(serviceName, "@") {
+			serviceName += "@" + router.Provider
+		}
+
+		route := containers.Route{
+			Router:   containers.TrimProvider(router.Name),
+			Provider: router.Provider,
+			Rule:     router.Rule,
+			Service:  containers.TrimProvider(serviceName),
+		}
+		if svc, ok := servicesByName[serviceName]; ok && svc.LoadBalancer != nil {
+			for _, server := range svc.LoadBalancer.Servers {
+				route.Servers = append(route.Servers, server.URL)
+			}
+		}
+		ret = append(ret, route)
+	}
+
+	return ret
+}
@@ -0,0 +1,61 @@
+package traefik
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newStubAPI(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/http/routers" && r.URL.Query().Get("page") == "1":
+			w.Header().Set("X-Next-Page", "2")
+			w.Write([]byte(`[
+				{"name": "web@docker", "provider": "docker", "rule": "Host(` + "`web.example.com`" + `)", "service": "web", "status": "enabled"},
+				{"name": "broken@docker", "provider": "docker", "rule": "Host(` + "`broken.example.com`" + `)", "service": "web", "status": "disabled"}
+			]`))
+		case r.URL.Path == "/api/http/routers" && r.URL.Query().Get("page") == "2":
+			w.Header().Set("X-Next-Page", "1")
+			w.Write([]byte(`[
+				{"name": "wiki@file", "provider": "file", "rule": "Host(` + "`wiki.example.com`" + `)", "service": "wiki-svc", "status": "enabled"}
+			]`))
+		case r.URL.Path == "/api/http/services":
+			w.Header().Set("X-Next-Page", "1")
+			w.Write([]byte(`[
+				{"name": "web@docker", "provider": "docker", "loadBalancer": {"servers": [{"url": "http://172.18.0.5:80"}]}},
+				{"name": "wiki-svc@file", "provider": "file", "loadBalancer": {"servers": [{"url": "http://wiki:3000"}]}}
+			]`))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestAPIClientRoutes(t *testing.T) {
+	srv := newStubAPI(t)
+	defer srv.Close()
+
+	routes, err := NewAPIClient(srv.URL+"/", nil).Routes(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []containers.Route{
+		{Router: "web", Provider: "docker", Rule: "Host(`web.example.com`)", Service: "web", Servers: []string{"http://172.18.0.5:80"}},
+		{Router: "wiki", Provider: "file", Rule: "Host(`wiki.example.com`)", Service: "wiki-svc", Servers: []string{"http://wiki:3000"}},
+	}, routes)
+}
+
+func TestAPIClientError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	_, err := NewAPIClient(srv.URL, nil).Routes(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,185 @@
+package traefik
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/sirupsen/logrus"
+	"go.yaml.in/yaml/v3"
+)
+
+// FileProvider reads routers and services from traefik's file-provider dynamic
+// configuration (a single file, or a directory of yaml/toml files)
+type FileProvider struct {
+	path string
+
+	mux    sync.RWMutex
+	routes []containers.Route
+}
+
+// Subset of traefik's dynamic configuration we care about
+type dynamicConfig struct {
+	HTTP struct {
+		Routers map[string]struct {
+			Rule    string `yaml:"rule" toml:"rule"`
+			Service string `yaml:"service" toml:"service"`
+		} `yaml:"routers" toml:"routers"`
+		Services map[string]struct {
+			LoadBalancer *LoadBalancer `yaml:"loadBalancer" toml:"loadBalancer"`
+		} `yaml:"services" toml:"services"`
+	} `yaml:"http" toml:"http"`
+}
+
+func NewFileProvider(path string) (*FileProvider, error) {
+	ret := &FileProvider{path: path}
+	if err := ret.Load(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Routes implements containers.RouteSource, returning the routes as of the last load
+func (s *FileProvider) Routes(ctx context.Context) ([]containers.Route, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.routes, nil
+}
+
+// Load (re)reads the dynamic configuration from disk
+func (s *FileProvider) Load() error {
+	files, err := s.configFiles()
+	if err != nil {
+		return err
+	}
+
+	var routers []Router
+	var services []Service
+	for _, file := range files {
+		cfg, err := parseDynamicConfig(file)
+		if err != nil {
+			return err
+		}
+		for name, r := range cfg.HTTP.Routers {
+			routers = append(routers, Router{Name: name + "@file", Provider: "file", Rule: r.Rule, Service: r.Service})
+		}
+		for name, svc := range cfg.HTTP.Services {
+			services = append(services, Service{Name: name + "@file", Provider: "file", LoadBalancer: svc.LoadBalancer})
+		}
+	}
+	sort.Slice(routers, func(i, j int) bool {
+		return routers[i].Name < routers[j].Name
+	})
+
+	routes := joinRoutes(routers, services)
+	logrus.Debugf("Loaded %d routes from traefik config %s", len(routes), s.path)
+
+	s.mux.Lock()
+	s.routes = routes
+	s.mux.Unlock()
+	return nil
+}
+
+// Watch the configuration for changes, reloading and calling onChange on each change.
+// Blocks until ctx is cancelled
+func (s *FileProvider) Watch(ctx context.Context, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// Watch the containing directory of a single file, so editors that replace it are still picked up
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	watchDir := s.path
+	if !info.IsDir() {
+		watchDir = filepath.Dir(s.path)
+	}
+	if err := watcher.Add(watchDir); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-watcher.Events:
+			if !s.isConfigFile(event.Name) {
+				continue
+			}
+			logrus.Infof("Traefik config %s changed, reloading", event.Name)
+			if err := s.Load(); err != nil {
+				logrus.Warnf("Unable to reload traefik config, keeping previous routes: %v", err)
+				continue
+			}
+			onChange()
+		case err := <-watcher.Errors:
+			logrus.Warnf("Error watching traefik config %s: %v", s.path, err)
+		}
+	}
+}
+
+func (s *FileProvider) configFiles() ([]string, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{s.path}, nil
+	}
+
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, entry := range entries {
+		if !entry.IsDir() && isConfigExt(entry.Name()) {
+			ret = append(ret, filepath.Join(s.path, entry.Name()))
+		}
+	}
+	return ret, nil
+}
+
+func (s *FileProvider) isConfigFile(name string) bool {
+	if filepath.Clean(name) == filepath.Clean(s.path) {
+		return true
+	}
+	return filepath.Dir(name) == filepath.Clean(s.path) && isConfigExt(name)
+}
+
+func isConfigExt(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yml", ".yaml", ".toml":
+		return true
+	}
+	return false
+}
+
+func parseDynamicConfig(file string) (*dynamicConfig, error) {
+	data, err := os.ReadFile(file) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg dynamicConfig
+	if strings.EqualFold(filepath.Ext(file), ".toml") {
+		err = toml.Unmarshal(data, &cfg)
+	} else {
+		err = yaml.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", file, err)
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,74 @@
+package traefik
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"traefik-lazyload/pkg/containers"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testYamlConfig = `
+http:
+  routers:
+    wiki:
+      rule: "Host(` + "`wiki.example.com`" + `)"
+      service: wiki
+    web:
+      rule: "Host(` + "`web.example.com`" + `)"
+      service: web@docker
+  services:
+    wiki:
+      loadBalancer:
+        servers:
+          - url: http://wiki:3000
+`
+
+const testTomlConfig = `
+[http.routers.git]
+rule = "Host(` + "`git.example.com`" + `)"
+service = "git"
+
+[[http.services.git.loadBalancer.servers]]
+url = "http://gitea:3000"
+`
+
+func TestFileProviderDirectory(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "routes.yml"), []byte(testYamlConfig), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "git.toml"), []byte(testTomlConfig), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("not config"), 0o600))
+
+	fp, err := NewFileProvider(dir)
+	assert.NoError(t, err)
+
+	routes, err := fp.Routes(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []containers.Route{
+		{Router: "git", Provider: "file", Rule: "Host(`git.example.com`)", Service: "git", Servers: []string{"http://gitea:3000"}},
+		{Router: "web", Provider: "file", Rule: "Host(`web.example.com`)", Service: "web"},
+		{Router: "wiki", Provider: "file", Rule: "Host(`wiki.example.com`)", Service: "wiki", Servers: []string{"http://wiki:3000"}},
+	}, routes)
+}
+
+func TestFileProviderReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dynamic.toml")
+	assert.NoError(t, os.WriteFile(file, []byte(testTomlConfig), 0o600))
+
+	fp, err := NewFileProvider(file)
+	assert.NoError(t, err)
+
+	// Broken config is rejected, keeping the previous routes
+	assert.NoError(t, os.WriteFile(file, []byte("[http.routers"), 0o600))
+	assert.Error(t, fp.Load())
+
+	routes, _ := fp.Routes(context.Background())
+	assert.Len(t, routes, 1)
+
+	assert.NoError(t, os.WriteFile(file, []byte(""), 0o600))
+	assert.NoError(t, fp.Load())
+	routes, _ = fp.Routes(context.Background())
+	assert.Empty(t, routes)
+}
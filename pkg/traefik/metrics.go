@@ -0,0 +1,140 @@
+package traefik
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const serviceRequestsMetric = "traefik_service_requests_total"
+
+// MetricsClient scrapes traefik's prometheus endpoint for per-service request counts
+type MetricsClient struct {
+	url    string
+	client *http.Client
+
+	mux  sync.Mutex
+	last map[string]float64 // service -> requests at last scrape
+}
+
+func NewMetricsClient(url string, client *http.Client) *MetricsClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &MetricsClient{
+		url:    url,
+		client: client,
+	}
+}
+
+// ServiceRequests scrapes the total requests per service (with @provider suffix)
+func (s *MetricsClient) ServiceRequests(ctx context.Context) (map[string]float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("traefik metrics: unexpected status %s", resp.Status)
+	}
+	return parseServiceRequests(resp.Body)
+}
+
+// ActiveServices returns the services whose request count grew since the previous call.
+// The first call only records a baseline
+func (s *MetricsClient) ActiveServices(ctx context.Context) ([]string, error) {
+	current, err := s.ServiceRequests(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var ret []string
+	if s.last != nil {
+		for service, count := range current {
+			// Lower than before means traefik restarted; any count since is new activity
+			if prev := s.last[service]; count > prev || (count < prev && count > 0) {
+				ret = append(ret, service)
+			}
+		}
+	}
+	s.last = current
+
+	return ret, nil
+}
+
+// parseServiceRequests sums traefik_service_requests_total over all labels but service,
+// from the prometheus text format
+func parseServiceRequests(r io.Reader) (map[string]float64, error) {
+	ret := make(map[string]float64)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, serviceRequestsMetric+"{") {
+			continue
+		}
+
+		labelEnd := strings.LastIndexByte(line, '}')
+		if labelEnd < 0 {
+			continue
+		}
+		service, ok := metricLabel(line[len(serviceRequestsMetric)+1:labelEnd], "service")
+		if !ok {
+			continue
+		}
+
+		// Value, optionally followed by a timestamp
+		fields := strings.Fields(line[labelEnd+1:])
+		if len(fields) == 0 {
+			continue
+		}
+		val, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		ret[service] += val
+	}
+
+	return ret, scanner.Err()
+}
+
+// metricLabel finds a label's value in a prometheus label set, eg. code="200",service="web@docker"
+func metricLabel(labels, name string) (string, bool) {
+	for labels != "" {
+		key, rest, ok := strings.Cut(labels, "=")
+		if !ok || !strings.HasPrefix(rest, `"`) {
+			return "", false
+		}
+
+		// Find the closing quote, skipping escaped ones
+		end := 1
+		for end < len(rest) && (rest[end] != '"' || rest[end-1] == '\\') {
+			end++
+		}
+		if end >= len(rest) {
+			return "", false
+		}
+
+		if strings.TrimSpace(key) == name {
+			val, err := strconv.Unquote(rest[:end+1])
+			return val, err == nil
+		}
+		labels = strings.TrimPrefix(rest[end+1:], ",")
+	}
+	return "", false
+}
@@ -0,0 +1,65 @@
+package traefik
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testMetricsTemplate = `# HELP traefik_service_requests_total How many HTTP requests processed on a service, partitioned by status code, protocol, and method.
+# TYPE traefik_service_requests_total counter
+traefik_service_requests_total{code="200",method="GET",protocol="http",service="web@docker"} %d
+traefik_service_requests_total{code="404",method="GET",protocol="http",service="web@docker"} 3
+traefik_service_requests_total{code="200",method="GET",protocol="http",service="wiki@file"} %d 1700000000000
+traefik_service_request_duration_seconds_bucket{code="200",method="GET",protocol="http",service="web@docker",le="0.1"} 100
+traefik_entrypoint_requests_total{code="200",entrypoint="http",method="GET",protocol="http"} 1000
+`
+
+func TestParseServiceRequests(t *testing.T) {
+	counts, err := parseServiceRequests(strings.NewReader(fmt.Sprintf(testMetricsTemplate, 10, 5)))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"web@docker": 13, "wiki@file": 5}, counts)
+}
+
+func TestMetricLabel(t *testing.T) {
+	val, ok := metricLabel(`code="200",service="web@docker"`, "service")
+	assert.True(t, ok)
+	assert.Equal(t, "web@docker", val)
+
+	val, ok = metricLabel(`path="/a\"b",service="x"`, "service")
+	assert.True(t, ok)
+	assert.Equal(t, "x", val)
+
+	_, ok = metricLabel(`code="200"`, "service")
+	assert.False(t, ok)
+}
+
+func TestMetricsClientActiveServices(t *testing.T) {
+	web, wiki := 10, 5
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, testMetricsTemplate, web, wiki)
+	}))
+	defer srv.Close()
+
+	client := NewMetricsClient(srv.URL, nil)
+	ctx := context.Background()
+
+	// Baseline
+	active, err := client.ActiveServices(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, active)
+
+	web = 12
+	active, err = client.ActiveServices(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"web@docker"}, active)
+
+	active, err = client.ActiveServices(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, active)
+}
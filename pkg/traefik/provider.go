@@ -0,0 +1,68 @@
+package traefik
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+var invalidRouterNameChars = regexp.MustCompile(`[^a-zA-Z0-9-]+`)
+
+// DynamicConfig is the traefik dynamic configuration served to traefik's http provider
+type DynamicConfig struct {
+	HTTP HTTPConfig `json:"http"`
+
+	names map[string]string // router name -> name it was sanitized from
+}
+
+type HTTPConfig struct {
+	Routers map[string]RouterConfig `json:"routers,omitempty"`
+}
+
+type RouterConfig struct {
+	Rule        string   `json:"rule"`
+	Service     string   `json:"service"`
+	Priority    int      `json:"priority,omitempty"`
+	EntryPoints []string `json:"entryPoints,omitempty"`
+}
+
+func NewDynamicConfig() *DynamicConfig {
+	return &DynamicConfig{
+		HTTP: HTTPConfig{
+			Routers: make(map[string]RouterConfig),
+		},
+		names: make(map[string]string),
+	}
+}
+
+// AddRouter adds a router, with its name sanitized for traefik. Names that only differ in
+// characters traefik doesn't allow (eg. a.b and a_b) get a hash suffix, rather than one
+// router replacing the other
+func (s *DynamicConfig) AddRouter(name string, router RouterConfig) {
+	key := RouterName(name)
+	if from, taken := s.names[key]; taken && from != name {
+		sum := sha256.Sum256([]byte(name))
+		key += "-" + hex.EncodeToString(sum[:4])
+	}
+	s.names[key] = name
+	s.HTTP.Routers[key] = router
+}
+
+// RouterName makes a name safe to use as a traefik router name
+func RouterName(name string) string {
+	return strings.Trim(invalidRouterNameChars.ReplaceAllString(name, "-"), "-")
+}
+
+// HostRule builds a traefik rule that matches exactly the given hosts and host regexps.
+// Uses one value per matcher, which is understood by both traefik v2 and v3
+func HostRule(hosts, patterns []string) string {
+	matchers := make([]string, 0, len(hosts)+len(patterns))
+	for _, host := range hosts {
+		matchers = append(matchers, "Host(`"+host+"`)")
+	}
+	for _, pattern := range patterns {
+		matchers = append(matchers, "HostRegexp(`"+pattern+"`)")
+	}
+	return strings.Join(matchers, " || ")
+}
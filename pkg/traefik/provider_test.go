@@ -0,0 +1,54 @@
+package traefik
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostRule(t *testing.T) {
+	assert.Equal(t, "", HostRule(nil, nil))
+	assert.Equal(t, "Host(`a.com`)", HostRule([]string{"a.com"}, nil))
+	assert.Equal(t, "Host(`a.com`) || Host(`b.com`) || HostRegexp(`^.+\\.c\\.com$`)",
+		HostRule([]string{"a.com", "b.com"}, []string{`^.+\.c\.com$`}))
+}
+
+func TestRouterName(t *testing.T) {
+	assert.Equal(t, "web", RouterName("web"))
+	assert.Equal(t, "lazyload-my-app-1", RouterName("lazyload-my_app.1"))
+	assert.Equal(t, "app", RouterName("/app/"))
+}
+
+func TestAddRouterCollision(t *testing.T) {
+	cfg := NewDynamicConfig()
+	cfg.AddRouter("lazyload-a.b", RouterConfig{Service: "first"})
+	cfg.AddRouter("lazyload-a_b", RouterConfig{Service: "second"})
+	cfg.AddRouter("lazyload-a_b", RouterConfig{Service: "replaced"}) // Same name, so the same router
+
+	assert.Len(t, cfg.HTTP.Routers, 2)
+	assert.Equal(t, "first", cfg.HTTP.Routers["lazyload-a-b"].Service)
+	for name, router := range cfg.HTTP.Routers {
+		if name != "lazyload-a-b" {
+			assert.Regexp(t, `^lazyload-a-b-[0-9a-f]{8}$`, name)
+			assert.Equal(t, "replaced", router.Service)
+		}
+	}
+}
+
+func TestDynamicConfigJSON(t *testing.T) {
+	cfg := NewDynamicConfig()
+	cfg.AddRouter("lazyload-web_1", RouterConfig{
+		Rule:     HostRule([]string{"web.example.com"}, nil),
+		Service:  "lazyload@docker",
+		Priority: -100,
+	})
+
+	data, err := json.Marshal(cfg)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"http": {"routers": {"lazyload-web-1": {
+		"rule": "Host(`+"`web.example.com`"+`)",
+		"service": "lazyload@docker",
+		"priority": -100
+	}}}}`, string(data))
+}
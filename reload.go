@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"traefik-lazyload/pkg/config"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Reloads the config when the file changes or on SIGHUP, until ctx is cancelled. Reloads only
+// happen here, one at a time, since viper isn't safe to use concurrently
+func (s *controller) WatchConfig(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var changes <-chan fsnotify.Event
+	var watchErrs <-chan error
+	path := viper.ConfigFileUsed()
+	if path != "" {
+		path = filepath.Clean(path)
+		// Watch the directory, as editors often replace the file rather than write to it
+		watcher, err := fsnotify.NewWatcher()
+		if err == nil {
+			err = watcher.Add(filepath.Dir(path))
+		}
+		if err != nil {
+			logrus.Warnf("Can't watch %s for changes, reload with SIGHUP instead: %v", path, err)
+		} else {
+			defer watcher.Close()
+			changes, watchErrs = watcher.Events, watcher.Errors
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			logrus.Info("Got SIGHUP, reloading config")
+			s.ReloadConfig()
+		case e := <-changes:
+			if filepath.Clean(e.Name) == path && e.Has(fsnotify.Write|fsnotify.Create) {
+				logrus.Infof("Config file %s changed, reloading", e.Name)
+				s.ReloadConfig()
+			}
+		case err := <-watchErrs:
+			logrus.Warnf("Watching %s: %v", path, err)
+		}
+	}
+}
+
+// ReloadConfig reads the config and applies it as a whole, or keeps the current one if it's invalid
+func (s *controller) ReloadConfig() error {
+	s.reloadMux.Lock()
+	defer s.reloadMux.Unlock()
+
+	next, err := config.Read()
+	if err == nil {
+		var assets *assetTemplates
+		if assets, err = loadTemplates(next.Splash); err == nil {
+			s.applyConfig(next, assets)
+		}
+	}
+
+	s.configErr = err
+	if err != nil {
+		logrus.Errorf("Rejected new config, keeping the current one: %v", err)
+	}
+	return err
+}
+
+func (s *controller) applyConfig(next *config.ConfigModel, assets *assetTemplates) {
+	current := config.Current()
+	if fields := config.RestartRequired(current, next); len(fields) > 0 {
+		logrus.Warnf("Changes to %v take effect after a restart", fields)
+	}
+	config.KeepRestartFields(current, next)
+
+	config.Apply(next)
+	s.assets.Store(assets)
+	s.core.SetPollRate(next.PollFreq)
+	if next.Verbose {
+		logrus.SetLevel(logrus.DebugLevel)
+	} else {
+		logrus.SetLevel(logrus.InfoLevel)
+	}
+	logrus.Info("Applied new config")
+}
+
+// Why the last reload was rejected, if it was
+func (s *controller) configError() string {
+	s.reloadMux.Lock()
+	defer s.reloadMux.Unlock()
+
+	if s.configErr == nil {
+		return ""
+	}
+	return s.configErr.Error()
+}